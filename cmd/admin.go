@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// addAdminFlags adds flags to the given command that enable the admin API.
+func addAdminFlags(cmd *cobra.Command) {
+	cmd.Flags().String("admin", "", `serve the admin API (used by "slackbridge ctl") on a Unix socket, given as a path containing '/' or as unix:PATH, or on a TCP address like 127.0.0.1:7777 (a bare :PORT listens on 127.0.0.1; other than loopback addresses require $SLACKBRIDGE_ADMIN_TOKEN)`)
+}
+
+// adminTokenEnv names the environment variable holding the bearer token that
+// requests to the admin API must present, if set.
+const adminTokenEnv = "SLACKBRIDGE_ADMIN_TOKEN"
+
+// adminBridge is a bridge that can be inspected through the admin API. Only
+// mux and exec bridges have children that can be controlled.
+type adminBridge struct {
+	name     string
+	mode     string
+	children childController // nil for other modes
+}
+
+// childController is implemented by bridges that run child processes, to
+// inspect and control them through the admin API.
+type childController interface {
+	// list describes the child process of each channel.
+	list() []childInfo
+	// signalChild sends a signal to the running child process of a channel.
+	signalChild(channelID string, sig os.Signal) error
+	// restartChild stops the child process of a channel if it is running, and
+	// starts it again.
+	restartChild(channelID string) error
+	// pauseChild pauses or resumes the input of a channel's child process.
+	pauseChild(channelID string, paused bool) error
+}
+
+// adminServer serves the admin API. The API has the following endpoints, which
+// respond with JSON:
+//
+//	GET  /v1/bridges                  list bridges and children
+//	POST /v1/children/CHANNEL/signal  signal a child ({"signal": "HUP"})
+//	POST /v1/children/CHANNEL/kill    kill a child
+//	POST /v1/children/CHANNEL/restart restart a child
+//	POST /v1/children/CHANNEL/pause   pause a child's input
+//	POST /v1/children/CHANNEL/resume  resume a child's input
+//
+// The children endpoints take their parameters as a JSON object in a body of
+// type application/json, which web pages can't send across origins without the
+// server's consent, and accept a "bridge" parameter to select a bridge by name.
+// The name is optional if only one bridge has a child for the channel.
+//
+// If a token is set, every request must present it as a bearer token in the
+// Authorization header. When serving on TCP, the Host header must name a
+// loopback address or "slackbridge" (as sent by ctl), so that pages served
+// from a rebound DNS name can't reach the API either.
+type adminServer struct {
+	bridges func() []adminBridge
+	token   string
+	tcp     bool
+	server  *http.Server
+}
+
+// adminParams are the parameters of the children endpoints.
+type adminParams struct {
+	Bridge string `json:"bridge,omitempty"`
+	Signal string `json:"signal,omitempty"`
+}
+
+// adminBridgeJSON and adminChildJSON are the representations of bridges and
+// children in the admin API.
+type adminBridgeJSON struct {
+	Name     string           `json:"name"`
+	Mode     string           `json:"mode"`
+	Children []adminChildJSON `json:"children,omitempty"`
+}
+
+type adminChildJSON struct {
+	ChannelID     string     `json:"channel_id"`
+	State         string     `json:"state"`
+	PID           int        `json:"pid,omitempty"`
+	Started       *time.Time `json:"started,omitempty"`
+	UptimeSeconds float64    `json:"uptime_seconds,omitempty"`
+	ExitCode      *int       `json:"exit_code,omitempty"`
+	Crashes       int        `json:"crashes,omitempty"`
+	BytesIn       int64      `json:"bytes_in"`
+	BytesOut      int64      `json:"bytes_out"`
+	LastActivity  *time.Time `json:"last_activity,omitempty"`
+	Paused        bool       `json:"paused,omitempty"`
+}
+
+// startAdminServer starts serving the admin API at the address given through
+// the flag added by addAdminFlags, or returns nil if the flag is blank.
+func startAdminServer(cmd *cobra.Command, bridges func() []adminBridge) (*adminServer, error) {
+	addr, _ := cmd.Flags().GetString("admin")
+	if addr == "" {
+		return nil, nil
+	}
+
+	token := os.Getenv(adminTokenEnv)
+	network, address := adminNetwork(addr)
+	if network == "tcp" && token == "" && !isLoopbackAddress(address) {
+		return nil, fmt.Errorf("the admin API can only be served on %s without authentication; set %s to serve it on other addresses", address, adminTokenEnv)
+	}
+	if network == "unix" {
+		// A socket left behind by an earlier run would prevent listening. Only
+		// sockets are removed, so that a mistyped path can't destroy a file.
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start admin API: %v", err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to start admin API: %v", err)
+		}
+	}
+
+	s := &adminServer{bridges: bridges, token: token, tcp: network == "tcp"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/bridges", s.handleBridges)
+	mux.HandleFunc("/v1/children/", s.handleChild)
+	s.server = &http.Server{Handler: s.authorize(mux)}
+
+	go func() {
+		if err := s.server.Serve(listener); err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "slackbridge: admin API failed: %v\n", err)
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "slackbridge: serving admin API on %s\n", listener.Addr())
+	return s, nil
+}
+
+// adminNetwork returns the network and address at which the admin API is
+// served, given its address as provided to --admin or ctl. A TCP address
+// without a host refers to 127.0.0.1, rather than to every interface.
+func adminNetwork(addr string) (network, address string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	if strings.ContainsRune(addr, '/') {
+		return "unix", addr
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return "tcp", net.JoinHostPort("127.0.0.1", port)
+	}
+	return "tcp", addr
+}
+
+// isLoopbackAddress returns whether a TCP address (with a port) names a
+// loopback interface.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorize wraps the handlers of the admin API to check the token and Host
+// header of each request.
+func (s *adminServer) authorize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.tcp && !isAdminHost(r.Host) {
+			writeAdminError(w, http.StatusForbidden, fmt.Errorf("host %q not allowed", r.Host))
+			return
+		}
+		if s.token != "" {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeAdminError(w, http.StatusUnauthorized, fmt.Errorf("a valid token from %s is required", adminTokenEnv))
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isAdminHost returns whether the Host header of a request to the admin API
+// over TCP is acceptable: an IP address, "localhost", or "slackbridge". Other
+// names could have been rebound by a web page to reach the API as its own
+// origin, and read its responses.
+func isAdminHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return host == "slackbridge" || host == "localhost" || net.ParseIP(host) != nil
+}
+
+// Close stops serving the admin API.
+func (s *adminServer) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+func (s *adminServer) handleBridges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	now := time.Now()
+	bridges := []adminBridgeJSON{}
+	for _, b := range s.bridges() {
+		bj := adminBridgeJSON{Name: b.name, Mode: b.mode}
+		if b.children != nil {
+			for _, info := range b.children.list() {
+				bj.Children = append(bj.Children, newAdminChildJSON(info, now))
+			}
+		}
+		bridges = append(bridges, bj)
+	}
+	writeAdminJSON(w, http.StatusOK, bridges)
+}
+
+func newAdminChildJSON(info childInfo, now time.Time) adminChildJSON {
+	cj := adminChildJSON{
+		ChannelID: info.ChannelID,
+		State:     info.State,
+		PID:       info.PID,
+		Crashes:   info.Crashes,
+		BytesIn:   info.BytesIn,
+		BytesOut:  info.BytesOut,
+		Paused:    info.Paused,
+	}
+	if !info.Started.IsZero() {
+		cj.Started = &info.Started
+	}
+	if info.State == childRunning || info.State == childStopping {
+		cj.UptimeSeconds = now.Sub(info.Started).Round(time.Millisecond).Seconds()
+	}
+	if info.ExitCode >= 0 {
+		cj.ExitCode = &info.ExitCode
+	}
+	if !info.LastActivity.IsZero() {
+		cj.LastActivity = &info.LastActivity
+	}
+	return cj
+}
+
+func (s *adminServer) handleChild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/children/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		writeAdminError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	channelID, action := parts[0], parts[1]
+
+	var params adminParams
+	if err := decodeAdminParams(r, &params); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	c, err := s.findController(params.Bridge, channelID)
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch action {
+	case "signal":
+		var sig os.Signal
+		if sig, err = parseSignal(params.Signal); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = c.signalChild(channelID, sig)
+	case "kill":
+		err = c.signalChild(channelID, os.Kill)
+	case "restart":
+		err = c.restartChild(channelID)
+	case "pause":
+		err = c.pauseChild(channelID, true)
+	case "resume":
+		err = c.pauseChild(channelID, false)
+	default:
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+
+	if err != nil {
+		writeAdminError(w, http.StatusConflict, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, struct{}{})
+}
+
+// decodeAdminParams decodes the JSON body of a POST request to the admin API.
+// Other types of body, which HTML forms could submit from any web page, are
+// rejected. An empty body is treated as an empty object.
+func decodeAdminParams(r *http.Request, params *adminParams) error {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		return errors.New("request body must be of type application/json")
+	}
+
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(params); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// findController returns the childController of the named bridge, or if name
+// is blank, of the only bridge that has a child for the channel.
+func (s *adminServer) findController(name, channelID string) (childController, error) {
+	var candidates []adminBridge
+	for _, b := range s.bridges() {
+		if b.children == nil {
+			continue
+		}
+		if name != "" && b.name == name {
+			return b.children, nil
+		}
+		if name == "" && hasChild(b.children, channelID) {
+			candidates = append(candidates, b)
+		}
+	}
+
+	switch {
+	case name != "":
+		return nil, fmt.Errorf("no bridge named %q has child processes", name)
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("no bridge has a child process for %s", channelID)
+	case len(candidates) > 1:
+		return nil, fmt.Errorf("several bridges have a child process for %s; select one by name", channelID)
+	}
+	return candidates[0].children, nil
+}
+
+func hasChild(c childController, channelID string) bool {
+	for _, info := range c.list() {
+		if info.ChannelID == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSignal parses a signal given by name (with or without a "SIG" prefix)
+// or by number.
+func parseSignal(s string) (os.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	switch name {
+	case "HUP":
+		return syscall.SIGHUP, nil
+	case "INT":
+		return syscall.SIGINT, nil
+	case "QUIT":
+		return syscall.SIGQUIT, nil
+	case "KILL":
+		return syscall.SIGKILL, nil
+	case "TERM":
+		return syscall.SIGTERM, nil
+	}
+	if sig, ok := platformSignals[name]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signal %q", s)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminNetwork(t *testing.T) {
+	testCases := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"/run/slackbridge.sock", "unix", "/run/slackbridge.sock"},
+		{"unix:admin.sock", "unix", "admin.sock"},
+		{":7777", "tcp", "127.0.0.1:7777"},
+		{"127.0.0.1:7777", "tcp", "127.0.0.1:7777"},
+		{"[::1]:7777", "tcp", "[::1]:7777"},
+		{"0.0.0.0:7777", "tcp", "0.0.0.0:7777"},
+	}
+
+	for _, tc := range testCases {
+		network, address := adminNetwork(tc.addr)
+		if network != tc.network || address != tc.address {
+			t.Errorf("adminNetwork(%q) = (%q, %q); want (%q, %q)", tc.addr, network, address, tc.network, tc.address)
+		}
+	}
+}
+
+func TestIsLoopbackAddress(t *testing.T) {
+	testCases := []struct {
+		address string
+		want    bool
+	}{
+		{"127.0.0.1:7777", true},
+		{"127.1.2.3:7777", true},
+		{"[::1]:7777", true},
+		{"localhost:7777", true},
+		{"0.0.0.0:7777", false},
+		{"[::]:7777", false},
+		{"192.0.2.1:7777", false},
+		{"example.com:7777", false},
+		{"127.0.0.1", false},
+	}
+
+	for _, tc := range testCases {
+		if got := isLoopbackAddress(tc.address); got != tc.want {
+			t.Errorf("isLoopbackAddress(%q) = %v; want %v", tc.address, got, tc.want)
+		}
+	}
+}
+
+func TestAdminServerAuthorization(t *testing.T) {
+	testCases := []struct {
+		name   string
+		token  string
+		host   string
+		auth   string
+		status int
+	}{
+		{"no token", "", "slackbridge", "", http.StatusOK},
+		{"loopback host", "", "127.0.0.1:7777", "", http.StatusOK},
+		{"localhost", "", "localhost:7777", "", http.StatusOK},
+		{"rebound host", "", "attacker.example:7777", "", http.StatusForbidden},
+		{"valid token", "secret", "slackbridge", "Bearer secret", http.StatusOK},
+		{"missing token", "secret", "slackbridge", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "slackbridge", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "secret", "slackbridge", "Basic secret", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		s := &adminServer{bridges: func() []adminBridge { return nil }, token: tc.token, tcp: true}
+		h := s.authorize(http.HandlerFunc(s.handleBridges))
+
+		req := httptest.NewRequest(http.MethodGet, "/v1/bridges", nil)
+		req.Host = tc.host
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: status = %d; want %d", tc.name, w.Code, tc.status)
+		}
+	}
+}
+
+func TestAdminServerRequiresJSON(t *testing.T) {
+	s := &adminServer{bridges: func() []adminBridge { return nil }}
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		err         string
+	}{
+		{"form", "application/x-www-form-urlencoded", "signal=HUP", http.StatusBadRequest, "must be of type application/json"},
+		{"text", "text/plain", `{"signal": "HUP"}`, http.StatusBadRequest, "must be of type application/json"},
+		{"no type", "", `{"signal": "HUP"}`, http.StatusBadRequest, "must be of type application/json"},
+		{"invalid JSON", "application/json", `{"signal": `, http.StatusBadRequest, "invalid request body"},
+		{"unknown field", "application/json", `{"sig": "HUP"}`, http.StatusBadRequest, "invalid request body"},
+		{"JSON", "application/json", `{"signal": "HUP"}`, http.StatusNotFound, "no bridge has a child process for C1"},
+		{"empty body", "application/json; charset=utf-8", "", http.StatusNotFound, "no bridge has a child process for C1"},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/v1/children/C1/signal", strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		w := httptest.NewRecorder()
+		s.handleChild(w, req)
+
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.err) {
+			t.Errorf("%s: response = %d %q; want %d containing %q", tc.name, w.Code, w.Body.String(), tc.status, tc.err)
+		}
+	}
+}
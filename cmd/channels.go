@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/directory"
+)
+
+var channelsCmd = &cobra.Command{
+	Use:     "channels",
+	Example: "channels --member --type public,private",
+	Short:   "List the Slack conversations that are visible to the API token",
+	Long: `Channels lists the conversations that are visible to the API token through
+Slack's conversations list API, with the ID of each conversation for use with
+the other commands. Direct messages are named after the other user, prefixed
+with "@".
+
+The "table" format is intended for people to read, while the "json" format
+writes a single JSON array with an object for each conversation.`,
+
+	Args: cobra.NoArgs,
+	Run:  runChannelsCmd,
+}
+
+func init() {
+	RootCmd.AddCommand(channelsCmd)
+	flags := channelsCmd.Flags()
+	flags.String("format", "table", `output format: "table" or "json"`)
+	flags.StringSlice("type", nil, `only list conversations of the provided type: "public", "private", "im", or "mpim" (repeatable)`)
+	flags.Bool("member", false, "only list conversations of which the user is a member")
+	flags.Bool("include-archived", false, "also list archived conversations")
+	flags.Bool("resolve-names", true, "name direct messages after the other user")
+}
+
+// channelRecord is the representation of a conversation in channels output.
+type channelRecord struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	IsMember   bool   `json:"is_member"`
+	NumMembers int    `json:"num_members,omitempty"`
+	IsArchived bool   `json:"is_archived,omitempty"`
+}
+
+func runChannelsCmd(cmd *cobra.Command, args []string) {
+	apiToken := os.Getenv("SLACK_TOKEN")
+	if apiToken == "" {
+		fmt.Fprintln(os.Stderr, "Error: SLACK_TOKEN environment variable not set")
+		fmt.Fprintln(os.Stderr, RootCmd.UsageString())
+		os.Exit(1)
+	}
+
+	flags := cmd.Flags()
+	format, _ := flags.GetString("format")
+	types, _ := flags.GetStringSlice("type")
+	memberOnly, _ := flags.GetBool("member")
+	includeArchived, _ := flags.GetBool("include-archived")
+	resolveNames, _ := flags.GetBool("resolve-names")
+
+	if format != "table" && format != "json" {
+		exitWithError(fmt.Errorf("unknown --format value %q", format))
+	}
+
+	// The conversations list API has its own names for each type.
+	apiTypes := map[string]string{
+		directory.TypePublic:  "public_channel",
+		directory.TypePrivate: "private_channel",
+		directory.TypeIM:      "im",
+		directory.TypeMPIM:    "mpim",
+	}
+	params := &slack.GetConversationsParameters{
+		ExcludeArchived: fmt.Sprint(!includeArchived),
+		Limit:           200,
+	}
+	for _, t := range types {
+		apiType, ok := apiTypes[t]
+		if !ok {
+			exitWithError(fmt.Errorf("unknown --type value %q", t))
+		}
+		params.Types = append(params.Types, apiType)
+	}
+	if params.Types == nil {
+		params.Types = []string{"public_channel", "private_channel", "mpim", "im"}
+	}
+
+	api := slack.New(apiToken)
+	dir := directory.New(api)
+
+	var records []channelRecord
+	for {
+		channels, nextCursor, err := api.GetConversations(params)
+		if err != nil {
+			exitWithError(err)
+		}
+
+		for _, ch := range channels {
+			record := channelRecord{
+				ID:         ch.ID,
+				Name:       ch.Name,
+				Type:       directory.ChannelType(&ch),
+				IsMember:   ch.IsMember || ch.IsIM || ch.IsMpIM,
+				NumMembers: ch.NumMembers,
+				IsArchived: ch.IsArchived,
+			}
+			if memberOnly && !record.IsMember {
+				continue
+			}
+
+			if record.Type == directory.TypeIM {
+				record.Name = "@" + ch.User
+				if resolveNames {
+					if user, err := dir.User(ch.User); err == nil {
+						record.Name = "@" + user.Name
+					}
+				}
+			}
+
+			records = append(records, record)
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		params.Cursor = nextCursor
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].ID < records[j].ID
+	})
+
+	if format == "json" {
+		if records == nil {
+			records = []channelRecord{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(records); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tMEMBER\tMEMBERS")
+	for _, r := range records {
+		name := r.Name
+		if r.IsArchived {
+			name += " (archived)"
+		}
+
+		member := "no"
+		if r.IsMember {
+			member = "yes"
+		}
+
+		members := "-"
+		if r.NumMembers > 0 {
+			members = fmt.Sprint(r.NumMembers)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.ID, name, r.Type, member, members)
+	}
+	if err := w.Flush(); err != nil {
+		exitWithError(err)
+	}
+}
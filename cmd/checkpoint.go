@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/checkpoint"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// addCheckpointFlags adds flags to the given command that control whether
+// positions within channels are saved, and where reading starts. Commands using
+// these flags should use openCheckpoints and replayPositions.
+func addCheckpointFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("state-dir", "", "directory in which to save the last processed message of each channel")
+	flags.Bool("from-checkpoint", true, "on startup, replay messages sent since the positions saved in --state-dir")
+	flags.String("since", "", "on startup, replay messages sent since the given time (a duration like 1h, an RFC 3339 time, or a Slack timestamp)")
+}
+
+// openCheckpoints returns the checkpoint.Store selected by --state-dir, or nil
+// if positions are not to be saved.
+func openCheckpoints(cmd *cobra.Command) (*checkpoint.Store, error) {
+	stateDir, _ := cmd.Flags().GetString("state-dir")
+	if stateDir == "" {
+		return nil, nil
+	}
+	return checkpoint.Open(stateDir)
+}
+
+// checkpointReaderOptions returns a copy of opts that saves the positions of
+// messages output by a Reader in store. If store is nil, opts is returned
+// unmodified.
+func checkpointReaderOptions(opts slackio.ReaderOptions, store *checkpoint.Store) slackio.ReaderOptions {
+	if store == nil {
+		return opts
+	}
+
+	opts.OnOutput = store.Recorder(func(err error) {
+		fmt.Fprintf(os.Stderr, "slackbridge: failed to save position: %v\n", err)
+	})
+	return opts
+}
+
+// replayPositions returns the positions from which messages should be replayed
+// on startup, for use with slackio.Client.Replay, for each of the given
+// channels. If channelIDs is empty, all channels are considered: with --since,
+// this means every conversation of which the user is a member, and otherwise
+// every channel with a saved position.
+func replayPositions(cmd *cobra.Command, apiToken string, store *checkpoint.Store, channelIDs []string) (map[string]string, error) {
+	flags := cmd.Flags()
+	since, _ := flags.GetString("since")
+	fromCheckpoint, _ := flags.GetBool("from-checkpoint")
+
+	if since != "" {
+		ts, err := parseTimeFlag("since", since, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		if len(channelIDs) == 0 {
+			if channelIDs, err = memberChannelIDs(apiToken); err != nil {
+				return nil, err
+			}
+		}
+
+		positions := make(map[string]string, len(channelIDs))
+		for _, id := range channelIDs {
+			positions[id] = ts
+		}
+		return positions, nil
+	}
+
+	if store == nil || !fromCheckpoint {
+		return nil, nil
+	}
+
+	if len(channelIDs) == 0 {
+		return store.All()
+	}
+
+	positions := make(map[string]string, len(channelIDs))
+	for _, id := range channelIDs {
+		ts, err := store.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		if ts != "" {
+			positions[id] = ts
+		}
+	}
+	return positions, nil
+}
+
+// replay replays messages from the given positions through client, reporting
+// any failures without stopping.
+func replay(client *slackio.Client, positions map[string]string) {
+	if len(positions) == 0 {
+		return
+	}
+
+	if err := client.Replay(positions); err != nil {
+		fmt.Fprintf(os.Stderr, "slackbridge: failed to replay messages: %v\n", err)
+	}
+}
+
+var slackTimestamp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// parseTimeFlag converts the value of a time flag (e.g. --since) to a Slack
+// timestamp. Durations are interpreted as that long before now.
+func parseTimeFlag(name, value string, now time.Time) (string, error) {
+	if slackTimestamp.MatchString(value) {
+		return value, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return formatTimestamp(now.Add(-d)), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return formatTimestamp(t), nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid --%s value %q", name, value)
+}
+
+func formatTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+}
+
+// memberChannelIDs returns the IDs of all conversations of which the user
+// associated with apiToken is a member.
+func memberChannelIDs(apiToken string) ([]string, error) {
+	api := slack.New(apiToken)
+	params := &slack.GetConversationsForUserParameters{
+		Types: []string{"public_channel", "private_channel", "mpim", "im"},
+		Limit: 200,
+	}
+
+	var ids []string
+	for {
+		channels, nextCursor, err := api.GetConversationsForUser(params)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ch := range channels {
+			ids = append(ids, ch.ID)
+		}
+
+		if nextCursor == "" {
+			return ids, nil
+		}
+		params.Cursor = nextCursor
+	}
+}
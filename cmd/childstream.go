@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// childStream tracks the input and output of a child process, counting the
+// bytes that pass through them, and allows its input to be paused.
+type childStream struct {
+	// Accessed atomically, and kept first for alignment on 32-bit platforms.
+	bytesIn, bytesOut int64
+	lastActive        int64 // in Unix nanoseconds
+
+	mu     sync.Mutex
+	resume chan struct{} // closed unless paused
+}
+
+func newChildStream(paused bool) *childStream {
+	s := &childStream{resume: make(chan struct{})}
+	if !paused {
+		close(s.resume)
+	}
+	return s
+}
+
+// setPaused pauses or resumes the input of the child process. While paused,
+// input is held back from the child (and received messages accumulate in the
+// Reader's buffer, subject to its limits).
+func (s *childStream) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.resume:
+		if paused {
+			s.resume = make(chan struct{})
+		}
+	default:
+		if !paused {
+			close(s.resume)
+		}
+	}
+}
+
+func (s *childStream) resumed() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resume
+}
+
+// stats returns the number of bytes passed to and from the child process, and
+// the time at which either last happened (zero if never).
+func (s *childStream) stats() (in, out int64, last time.Time) {
+	in, out = atomic.LoadInt64(&s.bytesIn), atomic.LoadInt64(&s.bytesOut)
+	if ns := atomic.LoadInt64(&s.lastActive); ns != 0 {
+		last = time.Unix(0, ns)
+	}
+	return
+}
+
+func (s *childStream) count(total *int64, n int) {
+	if n > 0 {
+		atomic.AddInt64(total, int64(n))
+		atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	}
+}
+
+// input wraps the Reader connected to the child's stdin.
+func (s *childStream) input(r io.ReadCloser) io.ReadCloser {
+	return &childInput{ReadCloser: r, stream: s, closed: make(chan struct{})}
+}
+
+// output wraps the Writer connected to the child's stdout.
+func (s *childStream) output(w io.WriteCloser) io.WriteCloser {
+	return &childOutput{WriteCloser: w, stream: s}
+}
+
+type childInput struct {
+	io.ReadCloser
+	stream *childStream
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Read waits while the stream is paused, then reads from the underlying
+// Reader. Waiting beforehand ensures that no message is taken from the Reader
+// (and recorded as output) only to be held back or lost if the child is closed.
+// A Read that is already blocked when the stream is paused still delivers the
+// next message.
+func (r *childInput) Read(p []byte) (int, error) {
+	select {
+	case <-r.stream.resumed():
+	case <-r.closed:
+		return 0, io.EOF
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.stream.count(&r.stream.bytesIn, n)
+	return n, err
+}
+
+// Close closes the underlying Reader, and interrupts a Read waiting for the
+// stream to be resumed.
+func (r *childInput) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return r.ReadCloser.Close()
+}
+
+type childOutput struct {
+	io.WriteCloser
+	stream *childStream
+}
+
+func (w *childOutput) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.stream.count(&w.stream.bytesOut, n)
+	return n, err
+}
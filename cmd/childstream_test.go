@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestChildInputPaused(t *testing.T) {
+	stream := newChildStream(true)
+	src := &countingReader{Reader: strings.NewReader("hello\n")}
+	in := stream.input(ioutil.NopCloser(src))
+
+	done := make(chan error)
+	go func() {
+		_, err := in.Read(make([]byte, 64))
+		done <- err
+	}()
+
+	in.Close()
+	if err := <-done; err != io.EOF {
+		t.Errorf("Read() returned %v after Close; want io.EOF", err)
+	}
+	if src.reads != 0 {
+		t.Errorf("paused input read from its source %d times; want 0", src.reads)
+	}
+}
+
+func TestChildInputResumed(t *testing.T) {
+	stream := newChildStream(true)
+	in := stream.input(ioutil.NopCloser(strings.NewReader("hello\n")))
+
+	done := make(chan string)
+	go func() {
+		p := make([]byte, 64)
+		n, _ := in.Read(p)
+		done <- string(p[:n])
+	}()
+
+	stream.setPaused(false)
+	if got := <-done; got != "hello\n" {
+		t.Errorf("Read() = %q; want %q", got, "hello\n")
+	}
+	if bytesIn, _, _ := stream.stats(); bytesIn != 6 {
+		t.Errorf("stats() reported %d bytes in; want 6", bytesIn)
+	}
+}
+
+type countingReader struct {
+	io.Reader
+	reads int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.reads++
+	return r.Reader.Read(p)
+}
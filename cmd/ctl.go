@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var ctlCmd = &cobra.Command{
+	Use:     "ctl COMMAND",
+	Example: "ctl --admin /run/slackbridge.sock list\n  ctl --admin /run/slackbridge.sock restart C12345678",
+	Short:   "Inspect and control a running mux or serve through its admin API",
+	Long: `Ctl connects to the admin API of a mux or serve command started with
+--admin, given here with --admin or the SLACKBRIDGE_ADMIN environment variable.
+If the admin API requires a token, it is read from SLACKBRIDGE_ADMIN_TOKEN.
+
+The list command shows each bridge (a mux command has a single bridge named
+"mux"), along with the child processes of mux and exec bridges: their process
+IDs, uptimes, the bytes passed to and from each, and when each was last active.
+
+The other commands act on the child process of a channel. If several bridges
+have a child for the channel, select one with --bridge.`,
+}
+
+var ctlListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List bridges and their child processes",
+	Args:  cobra.NoArgs,
+	Run:   runCtlListCmd,
+}
+
+var ctlSignalCmd = &cobra.Command{
+	Use:     "signal CHANNEL SIGNAL",
+	Example: "ctl signal C12345678 HUP",
+	Short:   "Send a signal (by name or number) to a channel's child process",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctlChildAction(cmd, args[0], "signal", adminParams{Signal: args[1]})
+	},
+}
+
+var ctlKillCmd = &cobra.Command{
+	Use:   "kill CHANNEL",
+	Short: "Kill a channel's child process",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctlChildAction(cmd, args[0], "kill", adminParams{})
+	},
+}
+
+var ctlRestartCmd = &cobra.Command{
+	Use:   "restart CHANNEL",
+	Short: "Stop a channel's child process if it is running, and start it again",
+	Long: `Restart stops a channel's child process if it is running, and starts it
+again with the same command line. The new process receives messages sent after
+it starts. A channel whose child was given up on for crashing is given a fresh
+start. The child of an exec bridge is restarted regardless of the bridge's
+restart policy, and at once if it is waiting to restart.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctlChildAction(cmd, args[0], "restart", adminParams{})
+	},
+}
+
+var ctlPauseCmd = &cobra.Command{
+	Use:   "pause CHANNEL",
+	Short: "Stop passing messages to a channel's child process until resumed",
+	Long: `Pause holds back the input of a channel's child process until it is resumed,
+including across restarts. Messages received in the meantime are buffered
+according to the --buffer-size and --overflow options of the bridge.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctlChildAction(cmd, args[0], "pause", adminParams{})
+	},
+}
+
+var ctlResumeCmd = &cobra.Command{
+	Use:   "resume CHANNEL",
+	Short: "Resume passing messages to a channel's child process",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctlChildAction(cmd, args[0], "resume", adminParams{})
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ctlCmd)
+	flags := ctlCmd.PersistentFlags()
+	flags.String("admin", os.Getenv("SLACKBRIDGE_ADMIN"), "address of the admin API, as given to --admin (defaults to $SLACKBRIDGE_ADMIN)")
+	flags.String("bridge", "", "name of the bridge whose child to act on")
+	flags.Duration("timeout", 30*time.Second, "maximum time to wait for a response")
+
+	ctlListCmd.Flags().String("format", "table", `output format: "table" or "json"`)
+	ctlCmd.AddCommand(ctlListCmd, ctlSignalCmd, ctlKillCmd, ctlRestartCmd, ctlPauseCmd, ctlResumeCmd)
+}
+
+func runCtlListCmd(cmd *cobra.Command, args []string) {
+	format, _ := cmd.Flags().GetString("format")
+	if format != "table" && format != "json" {
+		exitWithError(fmt.Errorf("unknown --format value %q", format))
+	}
+
+	var bridges []adminBridgeJSON
+	if err := ctlRequest(cmd, http.MethodGet, "/v1/bridges", nil, &bridges); err != nil {
+		exitWithError(err)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(bridges); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BRIDGE\tMODE\tCHANNEL\tSTATE\tPID\tUPTIME\tIN\tOUT\tLAST ACTIVE")
+	for _, b := range bridges {
+		if len(b.Children) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\t-\t-\t-\n", b.Name, b.Mode)
+			continue
+		}
+
+		for _, c := range b.Children {
+			state := c.State
+			if c.Paused {
+				state += " (paused)"
+			}
+			if c.ExitCode != nil && c.State != childStopped {
+				state += fmt.Sprintf(" (status %d)", *c.ExitCode)
+			}
+
+			pid, uptime, lastActive := "-", "-", "-"
+			if c.PID != 0 {
+				pid = fmt.Sprint(c.PID)
+			}
+			if c.UptimeSeconds > 0 {
+				uptime = (time.Duration(c.UptimeSeconds) * time.Second).String()
+			}
+			if c.LastActivity != nil {
+				lastActive = now.Sub(*c.LastActivity).Round(time.Second).String() + " ago"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+				b.Name, b.Mode, c.ChannelID, state, pid, uptime, c.BytesIn, c.BytesOut, lastActive)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		exitWithError(err)
+	}
+}
+
+func ctlChildAction(cmd *cobra.Command, channelID, action string, params adminParams) {
+	params.Bridge, _ = cmd.Flags().GetString("bridge")
+
+	path := "/v1/children/" + url.PathEscape(channelID) + "/" + action
+	if err := ctlRequest(cmd, http.MethodPost, path, &params, nil); err != nil {
+		exitWithError(err)
+	}
+}
+
+// ctlRequest makes a request to the admin API selected by the flags of the
+// ctl command, sending params (if non-nil) as a JSON body, and decodes a
+// successful response into result (if non-nil).
+func ctlRequest(cmd *cobra.Command, method, path string, params *adminParams, result interface{}) error {
+	addr, _ := cmd.Flags().GetString("admin")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	if addr == "" {
+		return errors.New("the address of the admin API must be given with --admin or SLACKBRIDGE_ADMIN")
+	}
+
+	network, address := adminNetwork(addr)
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		},
+	}
+
+	// The host is ignored by the dialer, and only appears in the request.
+	var body io.Reader
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://slackbridge"+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := os.Getenv(adminTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach admin API at %s: %v", addr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("admin API returned %s", resp.Status)
+		}
+		return errors.New(apiErr.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
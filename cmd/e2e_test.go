@@ -0,0 +1,503 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.alexhamlin.co/slackbridge/internal/slacktest"
+)
+
+// The tests in this file run slackbridge commands as separate processes
+// against a slacktest.Server. The test binary doubles as slackbridge: when run
+// with mainEnv set, TestMain executes the command given by its arguments.
+
+const (
+	mainEnv     = "SLACKBRIDGE_TEST_MAIN"
+	testTimeout = 10 * time.Second
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainEnv) != "" {
+		if err := RootCmd.Execute(); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// slackbridgeProcess is a slackbridge command running against a
+// slacktest.Server.
+type slackbridgeProcess struct {
+	t      *testing.T
+	cmd    *exec.Cmd
+	stdout syncBuffer
+	stderr syncBuffer
+	exited chan struct{}
+}
+
+// startSlackbridge runs the slackbridge command with the given arguments,
+// connected to server.
+func startSlackbridge(t *testing.T, server *slacktest.Server, args ...string) *slackbridgeProcess {
+	t.Helper()
+
+	p := &slackbridgeProcess{t: t, exited: make(chan struct{})}
+	p.cmd = runSlackbridge(server, args...)
+	p.cmd.Stdout, p.cmd.Stderr = &p.stdout, &p.stderr
+	if err := p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		p.cmd.Wait()
+		close(p.exited)
+	}()
+	return p
+}
+
+// runSlackbridge returns an exec.Cmd that runs the slackbridge command with the
+// given arguments, connected to server.
+func runSlackbridge(server *slacktest.Server, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(),
+		mainEnv+"=1",
+		"SLACK_API_URL="+server.URL,
+		"SLACK_TOKEN=xoxb-test",
+		"SLACK_APP_TOKEN=xapp-test",
+	)
+	return cmd
+}
+
+// stop terminates the process and waits for it to exit, killing it if it
+// takes too long.
+func (p *slackbridgeProcess) stop() {
+	p.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.exited:
+	case <-time.After(testTimeout):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
+
+// waitFor waits for cond to return true, failing the test if it does not
+// within testTimeout. If probe is non-nil, it is called periodically while
+// waiting, e.g. to send messages until the process is ready to receive them.
+func (p *slackbridgeProcess) waitFor(what string, cond func() bool, probe func()) {
+	p.t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for i := 0; !cond(); i++ {
+		select {
+		case <-p.exited:
+			p.t.Fatalf("slackbridge exited while waiting for %s; stderr:\n%s", what, p.stderr.String())
+		default:
+		}
+		if time.Now().After(deadline) {
+			p.t.Fatalf("timed out waiting for %s; stderr:\n%s", what, p.stderr.String())
+		}
+		if probe != nil && i%10 == 0 {
+			probe()
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// checkEcho runs a bridge of "cat" for a channel, and checks that a message
+// sent to the channel is posted back exactly once. As the fake server delivers
+// posted messages back to clients, a bridge that failed to ignore its own
+// messages would post them again and again. Messages posted by another process
+// with the same token, like the send command, must be ignored too.
+func checkEcho(t *testing.T, args ...string) {
+	server := slacktest.NewServer()
+	defer server.Close()
+
+	p := startSlackbridge(t, server, append(args, "--", "sh", "-c", "echo ready; exec cat")...)
+	defer p.stop()
+
+	p.waitFor("the child to start", hasPostedLine(server, "CGENERAL0", "ready"), nil)
+	server.SendMessage("CGENERAL0", "UHUMAN000", "hello")
+	p.waitFor("the echo", hasPostedLine(server, "CGENERAL0", "hello"), nil)
+
+	if out, err := runSlackbridge(server, "send", "--channel", "CGENERAL0", "notice").CombinedOutput(); err != nil {
+		t.Fatalf("send failed: %v\n%s", err, out)
+	}
+
+	// Anything posted in response to the earlier messages comes before the
+	// echo of a final one.
+	server.SendMessage("CGENERAL0", "UHUMAN000", "done")
+	p.waitFor("the last echo", hasPostedLine(server, "CGENERAL0", "done"), nil)
+
+	want := []string{"ready", "hello", "notice", "done"}
+	if got := postedLines(server, "CGENERAL0"); !equalStrings(got, want) {
+		t.Errorf("posted %q; want %q", got, want)
+	}
+}
+
+func TestExecRTM(t *testing.T) {
+	checkEcho(t, "exec", "--channel", "CGENERAL0")
+}
+
+func TestExecSocketMode(t *testing.T) {
+	checkEcho(t, "exec", "--channel", "CGENERAL0", "--receive-via", "socket")
+}
+
+func TestMux(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+
+	p := startSlackbridge(t, server, "mux", "--", "cat")
+	defer p.stop()
+
+	// Messages received before mux subscribes are not handled, so probe a
+	// separate channel until its child responds.
+	p.waitFor("mux to start", hasPostedLine(server, "CPROBE000", "probe"), func() {
+		server.SendMessage("CPROBE000", "UHUMAN000", "probe")
+	})
+
+	server.SendMessage("CALPHA000", "UHUMAN000", "alpha 1")
+	server.SendMessage("CBETA0000", "UHUMAN000", "beta 1")
+	server.SendMessage("CALPHA000", "UHUMAN000", "alpha 2")
+	server.SendMessage("CALPHA000", "UHUMAN000", "done")
+	server.SendMessage("CBETA0000", "UHUMAN000", "done")
+	p.waitFor("CALPHA000 to finish", hasPostedLine(server, "CALPHA000", "done"), nil)
+	p.waitFor("CBETA0000 to finish", hasPostedLine(server, "CBETA0000", "done"), nil)
+
+	for channelID, want := range map[string][]string{
+		"CALPHA000": {"alpha 1", "alpha 2", "done"},
+		"CBETA0000": {"beta 1", "done"},
+	} {
+		if got := postedLines(server, channelID); !equalStrings(got, want) {
+			t.Errorf("posted %q to %s; want %q", got, channelID, want)
+		}
+	}
+}
+
+func TestMuxStopsChildrenWhenClientFails(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "slackbridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "stopped")
+
+	child := `sleep 1000 & pid=$!; trap 'kill $pid; echo stopped > "$0"; exit' TERM; echo ready; wait`
+	p := startSlackbridge(t, server, "mux", "--receive-via", "socket", "--", "sh", "-c", child, marker)
+	defer p.stop()
+
+	p.waitFor("the child to start", hasPostedLine(server, "CGENERAL0", "ready"), func() {
+		server.SendMessage("CGENERAL0", "UHUMAN000", "probe")
+	})
+
+	server.RevokeToken("xapp-test")
+	select {
+	case <-p.exited:
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out waiting for slackbridge to exit; stderr:\n%s", p.stderr.String())
+	}
+
+	if code := p.cmd.ProcessState.ExitCode(); code != exitInvalidAuth {
+		t.Errorf("slackbridge exited with status %d; want %d", code, exitInvalidAuth)
+	}
+	if data, err := ioutil.ReadFile(marker); err != nil || string(data) != "stopped\n" {
+		t.Errorf("child was not stopped before slackbridge exited (%v); stderr:\n%s", err, p.stderr.String())
+	}
+}
+
+// postedLines returns the lines of the messages posted to a channel. Output
+// written within the batching interval may be combined into a single message,
+// so tests compare lines rather than messages.
+func postedLines(server *slacktest.Server, channelID string) []string {
+	var lines []string
+	for _, msg := range server.Posted() {
+		if msg.ChannelID == channelID {
+			lines = append(lines, strings.Split(msg.Text, "\n")...)
+		}
+	}
+	return lines
+}
+
+func hasPostedLine(server *slacktest.Server, channelID, line string) func() bool {
+	return func() bool {
+		for _, posted := range postedLines(server, channelID) {
+			if posted == line {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestStreamCheckpoint(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+
+	stateDir, err := ioutil.TempDir("", "slackbridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	args := []string{"stream", "--state-dir", stateDir, "--channel", "CGENERAL0"}
+	p := startSlackbridge(t, server, args...)
+
+	// Messages received before stream subscribes are not output, so probe until
+	// one is.
+	p.waitFor("stream to start", func() bool { return strings.Contains(p.stdout.String(), "ready") }, func() {
+		server.SendMessage("CGENERAL0", "UHUMAN000", "ready")
+	})
+
+	server.SendMessage("COTHER000", "UHUMAN000", "other")
+	oneTS := server.SendMessage("CGENERAL0", "UHUMAN000", "one")
+	p.waitFor("one", func() bool { return strings.Contains(p.stdout.String(), "one\n") }, nil)
+	p.stop()
+
+	if strings.Contains(p.stdout.String(), "other") {
+		t.Errorf("output %q contains a message from another channel", p.stdout.String())
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(stateDir, "CGENERAL0")); err != nil || strings.TrimSpace(string(data)) != oneTS {
+		t.Errorf("checkpoint for CGENERAL0 = %q, %v; want %q", data, err, oneTS)
+	}
+	// Nothing was output from the other channel, so its position must not be
+	// saved; a later run would otherwise skip its messages.
+	if _, err := os.Stat(filepath.Join(stateDir, "COTHER000")); !os.IsNotExist(err) {
+		t.Errorf("checkpoint saved for a channel that was never output (error %v)", err)
+	}
+
+	server.SendMessage("CGENERAL0", "UHUMAN000", "two")
+	server.SendMessage("CGENERAL0", "UHUMAN000", "three")
+
+	p = startSlackbridge(t, server, args...)
+	defer p.stop()
+	p.waitFor("the replay", func() bool { return strings.Contains(p.stdout.String(), "three\n") }, nil)
+
+	if got, want := p.stdout.String(), "two\nthree\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("replayed %q; want it to start with %q", got, want)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+
+	outputDir, err := ioutil.TempDir("", "slackbridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	// Enough messages for several pages of history.
+	var want []string
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			text := fmt.Sprintf("message %d", len(want))
+			server.SendMessage("CGENERAL0", "UHUMAN000", text)
+			want = append(want, text)
+		}
+	}
+	send(2*historyPageSize + 50)
+
+	out, err := runSlackbridge(server, "history", "-c", "CGENERAL0").Output()
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); !equalStrings(got, want) {
+		t.Errorf("history wrote %d lines out of order or incomplete; want %d", len(got), len(want))
+	}
+
+	// Repeated exports to the same directory only add new messages.
+	for _, n := range []int{0, 3} {
+		send(n)
+		if out, err := runSlackbridge(server, "history", "-c", "CGENERAL0", "--output-dir", outputDir).CombinedOutput(); err != nil {
+			t.Fatalf("history failed: %v\n%s", err, out)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "CGENERAL0.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); !equalStrings(got, want) {
+		t.Errorf("exports wrote %d lines out of order or incomplete; want %d", len(got), len(want))
+	}
+}
+
+func TestServeExecAdmin(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "slackbridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	config := `bridges:
+  - name: echo
+    mode: exec
+    channel: CGENERAL0
+    command: [sh, -c, "echo ready; exec cat"]
+`
+	if err := ioutil.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	adminPath := filepath.Join(dir, "admin.sock")
+	p := startSlackbridge(t, server, "serve", "--config", configPath, "--admin", adminPath)
+	defer p.stop()
+
+	p.waitFor("the child to start", hasPostedLine(server, "CGENERAL0", "ready"), nil)
+	server.SendMessage("CGENERAL0", "UHUMAN000", "hello")
+	p.waitFor("the echo", hasPostedLine(server, "CGENERAL0", "hello"), nil)
+
+	var child adminChildJSON
+	p.waitFor("the admin API", func() bool {
+		bridges, err := ctlList(server, adminPath)
+		if err != nil || len(bridges) != 1 || len(bridges[0].Children) != 1 {
+			return false
+		}
+		child = bridges[0].Children[0]
+		return true
+	}, nil)
+
+	if child.ChannelID != "CGENERAL0" || child.State != childRunning || child.PID == 0 || child.BytesIn != int64(len("hello\n")) {
+		t.Errorf("child = %+v; want CGENERAL0 running with a PID, having read %q", child, "hello\n")
+	}
+
+	if out, err := runSlackbridge(server, "ctl", "--admin", adminPath, "restart", "CGENERAL0").CombinedOutput(); err != nil {
+		t.Fatalf("ctl restart failed: %v\n%s", err, out)
+	}
+	p.waitFor("the child to restart", func() bool {
+		bridges, err := ctlList(server, adminPath)
+		if err != nil || len(bridges) != 1 || len(bridges[0].Children) != 1 {
+			return false
+		}
+		restarted := bridges[0].Children[0]
+		return restarted.State == childRunning && restarted.PID != child.PID
+	}, nil)
+}
+
+func TestServeExecAndMux(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "slackbridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	config := `bridges:
+  - name: echo
+    mode: exec
+    channel: CGENERAL0
+    command: [sh, -c, "echo ready; exec cat"]
+  - name: alpha
+    mode: mux
+    channel: CALPHA000
+    command: [cat]
+`
+	if err := ioutil.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	adminPath := filepath.Join(dir, "admin.sock")
+	p := startSlackbridge(t, server, "serve", "--config", configPath, "--admin", adminPath)
+	defer p.stop()
+
+	p.waitFor("the exec child to start", hasPostedLine(server, "CGENERAL0", "ready"), nil)
+	p.waitFor("the mux child to start", hasPostedLine(server, "CALPHA000", "probe"), func() {
+		server.SendMessage("CALPHA000", "UHUMAN000", "probe")
+	})
+
+	// A mux child for the exec bridge's channel would echo its messages again.
+	server.SendMessage("CGENERAL0", "UHUMAN000", "hello")
+	server.SendMessage("CBETA0000", "UHUMAN000", "ignored")
+	server.SendMessage("CGENERAL0", "UHUMAN000", "done")
+	p.waitFor("the echo", hasPostedLine(server, "CGENERAL0", "done"), nil)
+	server.SendMessage("CALPHA000", "UHUMAN000", "done")
+	p.waitFor("the mux child to finish", hasPostedLine(server, "CALPHA000", "done"), nil)
+
+	if got, want := postedLines(server, "CGENERAL0"), []string{"ready", "hello", "done"}; !equalStrings(got, want) {
+		t.Errorf("posted %q to CGENERAL0; want %q", got, want)
+	}
+	if got := postedLines(server, "CBETA0000"); len(got) > 0 {
+		t.Errorf("posted %q to CBETA0000; want nothing", got)
+	}
+
+	bridges, err := ctlList(server, adminPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children := make(map[string][]string)
+	for _, b := range bridges {
+		for _, child := range b.Children {
+			children[b.Name] = append(children[b.Name], child.ChannelID)
+		}
+	}
+	want := map[string][]string{"alpha": {"CALPHA000"}, "echo": {"CGENERAL0"}}
+	if !reflect.DeepEqual(children, want) {
+		t.Errorf("children = %v; want %v", children, want)
+	}
+}
+
+// ctlList runs "slackbridge ctl list" against the admin API at adminPath.
+func ctlList(server *slacktest.Server, adminPath string) ([]adminBridgeJSON, error) {
+	cmd := runSlackbridge(server, "ctl", "--admin", adminPath, "list", "--format", "json")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ctl list failed: %v", err)
+	}
+
+	var bridges []adminBridgeJSON
+	err = json.Unmarshal(out, &bridges)
+	return bridges, err
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
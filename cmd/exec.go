@@ -5,9 +5,9 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
-	"go.alexhamlin.co/slackio"
 
 	"go.alexhamlin.co/slackbridge/internal/childproc"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
 )
 
 var execCmd = &cobra.Command{
@@ -16,9 +16,10 @@ var execCmd = &cobra.Command{
 	Short:   "Connect a program's standard streams to a single Slack channel",
 	Long: `Exec runs a provided executable program and connects its standard
 input, output, and error streams to a single Slack channel. In this mode,
-text from the main body of the channel (i.e. excluding threads) is received
-by the executable on stdin. Text emitted on stdout and stderr is batched over
-a short time interval and sent as a single Slack message.`,
+text from the main body of the channel (i.e. excluding threads, unless
+--include-threads is given) is received by the executable on stdin. Text
+emitted on stdout and stderr is batched over a short time interval and sent as
+a single Slack message.`,
 
 	Args: cobra.MinimumNArgs(1),
 	Run:  runExecCmd,
@@ -28,6 +29,9 @@ func init() {
 	RootCmd.AddCommand(execCmd)
 	execCmd.Flags().StringP("channel", "c", "", "ID of the channel to connect to (required)")
 	execCmd.MarkFlagRequired("channel")
+	addInputFlags(execCmd)
+	addOutputFlags(execCmd)
+	addCheckpointFlags(execCmd)
 }
 
 func runExecCmd(cmd *cobra.Command, args []string) {
@@ -44,18 +48,62 @@ func runExecCmd(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
-	client := slackio.NewClient(apiToken)
-	reader := slackio.NewReader(client, slackChannel)
-	writer := slackio.NewWriter(client, slackChannel, nil)
+	readerOpts, err := readerOptions(cmd, apiToken, false)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	store, err := openCheckpoints(cmd)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	positions, err := replayPositions(cmd, apiToken, store, []string{slackChannel})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	client, err := newClient(cmd, apiToken)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	writeClient, err := newWriteClient(cmd, apiToken, client)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	reader := slackio.NewReaderWithOptions(newSubscriber(cmd, client, -1, slackChannel, writeClient), slackChannel, checkpointReaderOptions(readerOpts, store))
+	writer := slackio.NewWriter(writeClient, slackChannel, nil)
 
 	child, err := childproc.Spawn(args, reader, writer)
 	if err != nil {
 		panic(err)
 	}
 
+	// If the Client fails, the child will never receive another message, so we
+	// stop it as the mux command would on shutdown.
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-client.Failed():
+			terminateChild(child)
+		case <-exited:
+		}
+	}()
+
+	replay(client, positions)
+
 	// Note that Wait will close reader and writer for us after the child process
 	// terminates
-	if err := child.Wait(); err != nil {
+	err = child.Wait()
+	close(exited)
+
+	if clientErr := client.Err(); clientErr != nil {
+		client.Close()
+		exitWithError(clientErr)
+	}
+	if err != nil {
 		panic(err)
 	}
 
@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/directory"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// addFilterFlags adds flags to the given command that select which received
+// messages are output. Commands using these flags receive the selected filter
+// by passing filtered to readerOptions.
+func addFilterFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringSliceP("channel", "c", nil, "only output messages from the provided channel ID (repeatable)")
+	flags.StringSlice("exclude-channel", nil, "do not output messages from the provided channel ID (repeatable)")
+	flags.StringSlice("channel-type", nil, `only output messages from channels of the provided type: "public", "private", "im", or "mpim" (repeatable)`)
+	flags.StringSlice("user", nil, "only output messages from the provided user ID or name (repeatable)")
+	flags.StringSlice("exclude-user", nil, "do not output messages from the provided user ID or name (repeatable)")
+	flags.StringArray("match", nil, "only output messages whose text matches the provided regular expression (repeatable, any may match)")
+	flags.StringArray("exclude", nil, "do not output messages whose text matches the provided regular expression (repeatable)")
+}
+
+// addMuxFilterFlags adds flags to the given command that select the channels
+// for which child processes may be spawned in mux mode. Unlike the flags added
+// through addFilterFlags, these consider only the channel of each message.
+func addMuxFilterFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringSlice("only-channel", nil, "only handle the provided channel ID (repeatable)")
+	flags.StringSlice("skip-channel", nil, "do not handle the provided channel ID (repeatable)")
+	flags.StringSlice("only-type", nil, `only handle channels of the provided type: "public", "private", "im", or "mpim" (repeatable)`)
+	flags.StringArray("only-name", nil, `only handle channels whose names match the provided glob, like "ops-*" (repeatable)`)
+	flags.StringArray("skip-name", nil, "do not handle channels whose names match the provided glob (repeatable)")
+}
+
+// newMuxFilter returns the filter selected by the flags added through
+// addMuxFilterFlags, or nil if none are set.
+func newMuxFilter(cmd *cobra.Command, dir *directory.Directory) (*messageFilter, error) {
+	f := &messageFilter{dir: dir}
+	flags := cmd.Flags()
+
+	channels, _ := flags.GetStringSlice("only-channel")
+	excludeChannels, _ := flags.GetStringSlice("skip-channel")
+	channelTypes, _ := flags.GetStringSlice("only-type")
+	f.channels, f.excludeChannels = stringSet(channels), stringSet(excludeChannels)
+	f.channelTypes = stringSet(channelTypes)
+	names, _ := flags.GetStringArray("only-name")
+	excludeNames, _ := flags.GetStringArray("skip-name")
+	if len(names) > 0 {
+		f.names = names
+	}
+	if len(excludeNames) > 0 {
+		f.excludeNames = excludeNames
+	}
+
+	if err := validateChannelTypes("only-type", f.channelTypes); err != nil {
+		return nil, err
+	}
+	for _, glob := range append(f.names, f.excludeNames...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid channel name glob %q: %v", glob, err)
+		}
+	}
+
+	if f.channels == nil && f.excludeChannels == nil && f.channelTypes == nil &&
+		f.names == nil && f.excludeNames == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// filterChannels returns the channel IDs selected through --channel, if the
+// command supports it.
+func filterChannels(cmd *cobra.Command) []string {
+	channels, _ := cmd.Flags().GetStringSlice("channel")
+	return channels
+}
+
+// messageFilter is a filter for received messages, built from the flags added
+// through addFilterFlags or addMuxFilterFlags. Each kind of criterion that is
+// provided must be satisfied: a message must be from one of the listed
+// channels, of one of the listed channel types, and so on.
+type messageFilter struct {
+	dir *directory.Directory
+
+	channels, excludeChannels map[string]bool
+	channelTypes              map[string]bool
+	names, excludeNames       []string // channel name globs
+	users, excludeUsers       map[string]bool
+	match, exclude            []*regexp.Regexp
+}
+
+// newMessageFilter returns the filter selected by the flags added through
+// addFilterFlags, or nil if none are set.
+func newMessageFilter(cmd *cobra.Command, dir *directory.Directory) (*messageFilter, error) {
+	f := &messageFilter{dir: dir}
+	flags := cmd.Flags()
+
+	channels, _ := flags.GetStringSlice("channel")
+	excludeChannels, _ := flags.GetStringSlice("exclude-channel")
+	channelTypes, _ := flags.GetStringSlice("channel-type")
+	users, _ := flags.GetStringSlice("user")
+	excludeUsers, _ := flags.GetStringSlice("exclude-user")
+	f.channels, f.excludeChannels = stringSet(channels), stringSet(excludeChannels)
+	f.channelTypes = stringSet(channelTypes)
+	f.users, f.excludeUsers = stringSet(users), stringSet(excludeUsers)
+
+	if err := validateChannelTypes("channel-type", f.channelTypes); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if f.match, err = compileRegexps(cmd, "match"); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compileRegexps(cmd, "exclude"); err != nil {
+		return nil, err
+	}
+
+	if f.channels == nil && f.excludeChannels == nil && f.channelTypes == nil &&
+		f.users == nil && f.excludeUsers == nil && f.match == nil && f.exclude == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// accept reports whether the filter selects the given message.
+func (f *messageFilter) accept(m slackio.Message) bool {
+	if f.channels != nil && !f.channels[m.ChannelID] {
+		return false
+	}
+	if f.excludeChannels[m.ChannelID] {
+		return false
+	}
+
+	if f.channelTypes != nil && !f.channelTypes[f.channelType(m.ChannelID)] {
+		return false
+	}
+
+	// Channels whose names can't be determined are rejected by both kinds of
+	// name filter, rather than slipping past an exclusion.
+	if f.names != nil && !f.matchName(f.names, m.ChannelID, false) {
+		return false
+	}
+	if f.excludeNames != nil && f.matchName(f.excludeNames, m.ChannelID, true) {
+		return false
+	}
+
+	if f.users != nil && !f.matchUser(f.users, m.UserID) {
+		return false
+	}
+	if f.excludeUsers != nil && f.matchUser(f.excludeUsers, m.UserID) {
+		return false
+	}
+
+	if f.match != nil && !anyMatch(f.match, m.Text) {
+		return false
+	}
+	if anyMatch(f.exclude, m.Text) {
+		return false
+	}
+
+	return true
+}
+
+// channelType returns the type of the given channel, or a blank string if it
+// can't be determined.
+func (f *messageFilter) channelType(channelID string) string {
+	ch, err := f.dir.Channel(channelID)
+	if err == nil {
+		return ch.Type
+	}
+
+	// Direct messages are identifiable by ID alone, which saves them from being
+	// dropped if a lookup fails.
+	if strings.HasPrefix(channelID, "D") {
+		return directory.TypeIM
+	}
+	return ""
+}
+
+// matchName reports whether the name of the given channel matches any of the
+// provided globs, or returns unknown if the name can't be determined.
+func (f *messageFilter) matchName(globs []string, channelID string, unknown bool) bool {
+	ch, err := f.dir.Channel(channelID)
+	if err != nil {
+		return unknown
+	}
+
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, ch.Name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchUser reports whether the given user appears in set, by either ID or
+// name.
+func (f *messageFilter) matchUser(set map[string]bool, userID string) bool {
+	if set[userID] {
+		return true
+	}
+	if userID == "" {
+		return false
+	}
+
+	user, err := f.dir.User(userID)
+	return err == nil && set[user.Name]
+}
+
+func validateChannelTypes(flag string, types map[string]bool) error {
+	for t := range types {
+		switch t {
+		case directory.TypePublic, directory.TypePrivate, directory.TypeIM, directory.TypeMPIM:
+		default:
+			return fmt.Errorf("unknown --%s value %q", flag, t)
+		}
+	}
+	return nil
+}
+
+func stringSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func compileRegexps(cmd *cobra.Command, name string) ([]*regexp.Regexp, error) {
+	exprs, _ := cmd.Flags().GetStringArray(name)
+
+	var res []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s: %v", name, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func anyMatch(res []*regexp.Regexp, text string) bool {
+	for _, re := range res {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+	"go.alexhamlin.co/slackbridge/internal/slacktest"
+)
+
+func TestReaderOptionsFilterAndTemplate(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+
+	apiURL := slack.APIURL
+	slack.APIURL = server.URL
+	defer func() { slack.APIURL = apiURL }()
+
+	server.AddUser(slack.User{ID: "UALICE000", Name: "alice"})
+	server.AddUser(slack.User{ID: "UBOB00000", Name: "bob"})
+	secret := slack.Channel{IsChannel: true}
+	secret.ID, secret.Name, secret.IsPrivate = "CSECRET00", "secret", true
+	server.AddChannel(secret)
+	im := slack.Channel{}
+	im.ID, im.IsIM, im.User = "DALICE000", true, "UALICE000"
+	server.AddChannel(im)
+
+	msgs := []slackio.Message{
+		{ChannelID: "CGENERAL0", UserID: "UALICE000", Timestamp: "1500000001.000100", Text: "hello world"},
+		{ChannelID: "CSECRET00", UserID: "UBOB00000", Timestamp: "1500000002.000100", Text: "deploy done"},
+		{ChannelID: "DALICE000", UserID: "UALICE000", Timestamp: "1500000003.000100", Text: "hi bot"},
+	}
+
+	// Each case lists the output for each of msgs, or "-" if it is filtered out.
+	testCases := []struct {
+		args     []string
+		filtered bool
+		want     []string
+	}{
+		{
+			args:     nil,
+			filtered: true,
+			want:     []string{"hello world", "deploy done", "hi bot"},
+		},
+		{
+			args:     []string{"--channel", "CGENERAL0", "--template", "{{.Channel}} {{.User}}: {{.Text}}"},
+			filtered: true,
+			want:     []string{"general alice: hello world", "-", "-"},
+		},
+		{
+			args:     []string{"--channel", "CGENERAL0,CSECRET00", "--exclude-channel", "CGENERAL0"},
+			filtered: true,
+			want:     []string{"-", "deploy done", "-"},
+		},
+		{
+			args:     []string{"--channel-type", "private,im", "--template", "{{.ChannelType}} {{.Channel}}"},
+			filtered: true,
+			want:     []string{"-", "private secret", "im @alice"},
+		},
+		{
+			args:     []string{"--user", "alice", "--template", "{{.UserID}} {{.Time.Unix}}"},
+			filtered: true,
+			want:     []string{"UALICE000 1500000001", "-", "UALICE000 1500000003"},
+		},
+		{
+			args:     []string{"--exclude-user", "UBOB00000", "--match", "^h", "--exclude", "world"},
+			filtered: true,
+			want:     []string{"-", "-", "hi bot"},
+		},
+		{
+			args:     []string{"--match", "world", "--match", "deploy", "--template", "{{.Timestamp}}"},
+			filtered: true,
+			want:     []string{"1500000001.000100", "1500000002.000100", "-"},
+		},
+		{
+			// Commands without filter flags ignore them, but not their templates.
+			args:     []string{"--channel", "CGENERAL0", "--template", "{{.User}}: {{.Text}}"},
+			filtered: false,
+			want:     []string{"alice: hello world", "bob: deploy done", "alice: hi bot"},
+		},
+	}
+
+	for _, tc := range testCases {
+		cmd := newReaderTestCmd(t, tc.args...)
+		opts, err := readerOptions(cmd, "xoxb-test", tc.filtered)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.args, err)
+			continue
+		}
+
+		var got []string
+		for _, m := range msgs {
+			switch {
+			case opts.Filter != nil && !opts.Filter(m):
+				got = append(got, "-")
+			case opts.Format != nil:
+				got = append(got, opts.Format(m))
+			default:
+				got = append(got, m.Text)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: output %q; want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestReaderOptionsErrors(t *testing.T) {
+	testCases := [][]string{
+		{"--channel-type", "public,group"},
+		{"--match", "("},
+		{"--exclude", "[a-"},
+		{"--template", "{{.Nonexistent}}"},
+		{"--template", "{{.Text"},
+	}
+
+	for _, args := range testCases {
+		cmd := newReaderTestCmd(t, args...)
+		if _, err := readerOptions(cmd, "xoxb-test", true); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+// newReaderTestCmd returns a command with the flags of the stream command,
+// parsed from args.
+func newReaderTestCmd(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+
+	cmd := &cobra.Command{Use: "test"}
+	addFilterFlags(cmd)
+	addInputFlags(cmd)
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
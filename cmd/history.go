@@ -0,0 +1,374 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/checkpoint"
+	"go.alexhamlin.co/slackbridge/internal/directory"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// historyPageSize is the number of messages requested in each page of channel
+// history and thread replies.
+const historyPageSize = 200
+
+var historyCmd = &cobra.Command{
+	Use:     "history",
+	Example: "history -c C12345678 --since 24h --format=jsonl",
+	Short:   "Write the message history of a Slack channel to stdout",
+	Long: `History pages through the message history of a single Slack channel using
+Slack's conversations history API, and writes the messages from oldest to
+newest. Unlike the other commands, it does not need to be running when the
+messages are sent.
+
+The "text" format renders each line of each message as with the stream
+command, including support for --template. The "jsonl" format writes one JSON
+object per message, with the channel, timestamp, time, thread, user (with the
+user's name, unless --resolve-names=false), and text of the message.
+
+With --output-dir, messages are appended to a file named after the channel in
+the given directory instead of being written to stdout, and the position of
+the last exported message is saved alongside it. Repeated runs with the same
+directory only fetch messages sent since the previous run. The position is
+saved periodically during the export, after the messages before it have been
+written; if history is interrupted, the next run may write up to a few hundred
+of the last messages again. Note that replies added to a thread after its
+parent message was exported are not fetched.`,
+
+	Args: cobra.NoArgs,
+	Run:  runHistoryCmd,
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+	flags := historyCmd.Flags()
+	flags.StringP("channel", "c", "", "ID of the channel to export (required)")
+	historyCmd.MarkFlagRequired("channel")
+	flags.String("since", "", "only export messages sent after the given time (a duration like 1h, an RFC 3339 time, or a Slack timestamp)")
+	flags.String("until", "", "only export messages sent before the given time (in the same formats as --since)")
+	flags.String("format", "text", `output format: "text" or "jsonl"`)
+	flags.Bool("resolve-names", true, "include user names in jsonl output")
+	flags.String("output-dir", "", "append messages to a file in this directory, and only fetch messages sent since the previous export")
+	addRenderFlags(historyCmd)
+}
+
+// historyRecord is the representation of a message in jsonl output.
+type historyRecord struct {
+	ChannelID       string    `json:"channel_id"`
+	Timestamp       string    `json:"ts"`
+	Time            time.Time `json:"time"`
+	ThreadTimestamp string    `json:"thread_ts,omitempty"`
+	Broadcast       bool      `json:"broadcast,omitempty"`
+	UserID          string    `json:"user_id,omitempty"`
+	User            string    `json:"user,omitempty"`
+	Text            string    `json:"text"`
+}
+
+func runHistoryCmd(cmd *cobra.Command, args []string) {
+	apiToken := os.Getenv("SLACK_TOKEN")
+	if apiToken == "" {
+		fmt.Fprintln(os.Stderr, "Error: SLACK_TOKEN environment variable not set")
+		fmt.Fprintln(os.Stderr, RootCmd.UsageString())
+		os.Exit(1)
+	}
+
+	flags := cmd.Flags()
+	channelID, _ := flags.GetString("channel")
+	format, _ := flags.GetString("format")
+	resolveNames, _ := flags.GetBool("resolve-names")
+	includeThreads, _ := flags.GetBool("include-threads")
+	outputDir, _ := flags.GetString("output-dir")
+
+	if format != "text" && format != "jsonl" {
+		exitWithError(fmt.Errorf("unknown --format value %q", format))
+	}
+
+	var oldest, latest string
+	var err error
+	now := time.Now()
+	if since, _ := flags.GetString("since"); since != "" {
+		if oldest, err = parseTimeFlag("since", since, now); err != nil {
+			exitWithError(err)
+		}
+	}
+	if until, _ := flags.GetString("until"); until != "" {
+		if latest, err = parseTimeFlag("until", until, now); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	api := slack.New(apiToken)
+	dir := directory.New(api)
+
+	// The text format renders messages exactly as a Reader would.
+	var render slackio.ReaderOptions
+	if format == "text" {
+		tmpl, err := parseMessageTemplate(cmd, "template", dir)
+		if err != nil {
+			exitWithError(err)
+		}
+		if tmpl != nil {
+			render.Format = tmpl.render
+		}
+		if render.ThreadPrefix, err = threadPrefix(cmd, dir); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	var store *checkpoint.Store
+	var outFile *os.File
+	if outputDir != "" {
+		if store, err = checkpoint.Open(filepath.Join(outputDir, ".positions")); err != nil {
+			exitWithError(err)
+		}
+
+		saved, err := store.Load(channelID)
+		if err != nil {
+			exitWithError(err)
+		}
+		if saved != "" && (oldest == "" || slackio.TimestampAfter(saved, oldest)) {
+			oldest = saved
+		}
+
+		ext := ".txt"
+		if format == "jsonl" {
+			ext = ".jsonl"
+		}
+		path := filepath.Join(outputDir, channelID+ext)
+		if outFile, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+			exitWithError(err)
+		}
+		out = outFile
+	}
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	write := func(m slackio.Message) {
+		if format == "text" {
+			w.WriteString(render.Render(m))
+			return
+		}
+
+		record := historyRecord{
+			ChannelID:       m.ChannelID,
+			Timestamp:       m.Timestamp,
+			Time:            timestampTime(m.Timestamp),
+			ThreadTimestamp: m.ThreadTimestamp,
+			Broadcast:       m.Broadcast,
+			UserID:          m.UserID,
+			Text:            m.Text,
+		}
+		if resolveNames && m.UserID != "" {
+			if user, err := dir.User(m.UserID); err == nil {
+				record.User = user.Name
+			}
+		}
+		enc.Encode(record)
+	}
+
+	// With --output-dir, the position of the last exported message is saved
+	// after every page of messages, as well as at the end. Messages are always
+	// written before their position is saved, so if history is interrupted in
+	// between, the next run writes up to a page of messages again rather than
+	// skipping any.
+	var last string
+	checkpointOutput := func() {
+		if err := w.Flush(); err != nil {
+			exitWithError(err)
+		}
+		if last != "" {
+			if err := store.Save(channelID, last); err != nil {
+				exitWithError(err)
+			}
+		}
+	}
+
+	err = channelHistory(api, channelID, oldest, latest, func(msgs []slack.Message) {
+		for _, hm := range msgs {
+			m := historyMessage(channelID, hm)
+
+			// Broadcast replies appear in the main body of the channel as well as
+			// in their threads. As with Readers, they are only output with the rest
+			// of their threads.
+			if m.ThreadTimestamp != "" || m.Text == "" {
+				continue
+			}
+
+			write(m)
+			last = m.Timestamp
+
+			if includeThreads && hm.ReplyCount > 0 {
+				replies, err := threadReplies(api, channelID, hm.Timestamp)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "slackbridge: failed to fetch replies to %s: %v\n", hm.Timestamp, err)
+				}
+				for _, reply := range replies {
+					if reply.Text != "" {
+						write(historyMessage(channelID, reply))
+					}
+				}
+			}
+		}
+
+		if store != nil {
+			checkpointOutput()
+		}
+	})
+	if err != nil {
+		w.Flush()
+		exitWithError(err)
+	}
+
+	if store == nil {
+		if err := w.Flush(); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	checkpointOutput()
+	if err := outFile.Close(); err != nil {
+		exitWithError(err)
+	}
+}
+
+// historyPage identifies a page of channel history by the timestamps of its
+// oldest and newest messages.
+type historyPage struct {
+	oldest, latest string
+}
+
+// channelHistory calls fn with the messages in the main body of a channel that
+// were sent after oldest and before latest (either of which may be blank), one
+// page at a time, ordered from oldest to newest.
+//
+// Slack pages through history from newest to oldest. Rather than holding the
+// entire history in memory to reverse it, channelHistory first pages through
+// it noting only where each page begins and ends, then fetches the pages again
+// from oldest to newest. The oldest page, which is fetched last, is passed to
+// fn without being fetched again.
+func channelHistory(api *slack.Client, channelID, oldest, latest string, fn func([]slack.Message)) error {
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Oldest:    oldest,
+		Latest:    latest,
+		Limit:     historyPageSize,
+	}
+
+	var pages []historyPage
+	var msgs []slack.Message
+	for {
+		resp, err := api.GetConversationHistory(params)
+		if err != nil {
+			return err
+		}
+
+		msgs = resp.Messages
+		if !resp.HasMore || resp.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		if n := len(msgs); n > 0 {
+			pages = append(pages, historyPage{oldest: msgs[n-1].Timestamp, latest: msgs[0].Timestamp})
+		}
+		params.Cursor = resp.ResponseMetaData.NextCursor
+	}
+	fn(slackio.ChronologicalHistory(msgs))
+
+	for i := len(pages) - 1; i >= 0; i-- {
+		msgs, err := historyPageMessages(api, channelID, pages[i])
+		if err != nil {
+			return err
+		}
+		fn(slackio.ChronologicalHistory(msgs))
+	}
+	return nil
+}
+
+// historyPageMessages fetches a page of channel history again, returning its
+// messages ordered from newest to oldest.
+func historyPageMessages(api *slack.Client, channelID string, page historyPage) ([]slack.Message, error) {
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Oldest:    page.oldest,
+		Latest:    page.latest,
+		Inclusive: true,
+		Limit:     historyPageSize,
+	}
+
+	// Messages are never added within the page, but a page is still followed
+	// to its end in case Slack splits it differently.
+	var msgs []slack.Message
+	for {
+		resp, err := api.GetConversationHistory(params)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, resp.Messages...)
+		if !resp.HasMore || resp.ResponseMetaData.NextCursor == "" {
+			return msgs, nil
+		}
+		params.Cursor = resp.ResponseMetaData.NextCursor
+	}
+}
+
+// threadReplies returns the replies in the thread with the given parent
+// message, ordered from oldest to newest.
+func threadReplies(api *slack.Client, channelID, ts string) ([]slack.Message, error) {
+	params := &slack.GetConversationRepliesParameters{
+		ChannelID: channelID,
+		Timestamp: ts,
+		Limit:     historyPageSize,
+	}
+
+	var replies []slack.Message
+	for {
+		msgs, hasMore, nextCursor, err := api.GetConversationReplies(params)
+		if err != nil {
+			return nil, err
+		}
+
+		// The parent message is included with the replies.
+		for _, m := range msgs {
+			if m.Timestamp != ts {
+				replies = append(replies, m)
+			}
+		}
+
+		if !hasMore || nextCursor == "" {
+			return replies, nil
+		}
+		params.Cursor = nextCursor
+	}
+}
+
+// historyMessage converts a message from Slack's history APIs to a
+// slackio.Message, as if a Client had received it. Messages from
+// conversations.history must first be passed through
+// slackio.ChronologicalHistory.
+func historyMessage(channelID string, m slack.Message) slackio.Message {
+	msg := slackio.Message{
+		ChannelID:       channelID,
+		Text:            m.Text,
+		Timestamp:       m.Timestamp,
+		UserID:          m.User,
+		ThreadTimestamp: m.ThreadTimestamp,
+		Broadcast:       m.SubType == "thread_broadcast",
+	}
+	if msg.UserID == "" {
+		msg.UserID = m.BotID
+	}
+	return msg
+}
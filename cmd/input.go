@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/directory"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// addInputFlags adds flags to the given command that control how messages are
+// received from Slack. Commands using these flags should construct their
+// slackio.Client with newClient.
+func addInputFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("receive-via", "rtm", `API used to receive messages: "rtm" (real-time), "socket" (Socket Mode, requires SLACK_APP_TOKEN), or "events" (Events API over HTTP, requires SLACK_SIGNING_SECRET)`)
+	flags.String("listen", ":8080", "address on which to serve Events API requests (requires --receive-via=events)")
+	flags.Int("buffer-size", slackio.DefaultQueueSize, "maximum number of received messages to buffer for slow readers (0 for no limit)")
+	flags.Duration("buffer-retention", 0, "maximum age of buffered messages (0 for no limit)")
+	flags.String("overflow", "skip", `behavior when a reader falls behind the buffer: "skip" (silently drop messages), "block" (delay new messages, up to --overflow-max-block), or "report" (drop messages with a warning)`)
+	flags.Duration("overflow-max-block", slackio.DefaultMaxBlock, "longest to delay each new message with --overflow=block")
+	addRenderFlags(cmd)
+}
+
+// readerOptions returns the slackio.ReaderOptions selected by the flags added
+// through addInputFlags, and through addFilterFlags if filtered is set. The
+// templates are executed with a templateMessage, and names are resolved using
+// the given API token.
+func readerOptions(cmd *cobra.Command, apiToken string, filtered bool) (slackio.ReaderOptions, error) {
+	var opts slackio.ReaderOptions
+	dir := directory.New(slack.New(apiToken))
+
+	format, err := parseMessageTemplate(cmd, "template", dir)
+	if err != nil {
+		return opts, err
+	}
+	if format != nil {
+		opts.Format = format.render
+	}
+
+	if filtered {
+		filter, err := newMessageFilter(cmd, dir)
+		if err != nil {
+			return opts, err
+		}
+		if filter != nil {
+			opts.Filter = filter.accept
+		}
+	}
+
+	opts.IncludeThreads, _ = cmd.Flags().GetBool("include-threads")
+	if !opts.IncludeThreads {
+		return opts, nil
+	}
+
+	opts.ThreadPrefix, err = threadPrefix(cmd, dir)
+	return opts, err
+}
+
+// clientSetupTimeout bounds the verification of API credentials when a Client
+// is created.
+const clientSetupTimeout = 30 * time.Second
+
+// newClient returns a slackio.Client connected to Slack using the API selected
+// by the flags added through addInputFlags, after verifying that Slack accepts
+// its credentials. If the Client later fails permanently (e.g. because its
+// token is revoked), the command should stop any child processes as it would
+// on shutdown, then exit through exitWithError with the Client's error.
+func newClient(cmd *cobra.Command, apiToken string) (*slackio.Client, error) {
+	return newClientWithAppToken(cmd, apiToken, "SLACK_APP_TOKEN")
+}
+
+// newClientWithAppToken is like newClient, but reads the app-level token for
+// --receive-via=socket from the named environment variable, for commands that
+// connect to more than one workspace.
+func newClientWithAppToken(cmd *cobra.Command, apiToken, appTokenVar string) (*slackio.Client, error) {
+	flags := cmd.Flags()
+	receiveVia, _ := flags.GetString("receive-via")
+
+	bufferSize, _ := flags.GetInt("buffer-size")
+	bufferRetention, _ := flags.GetDuration("buffer-retention")
+	if bufferSize <= 0 && bufferRetention <= 0 {
+		return nil, fmt.Errorf("--buffer-size=0 requires a non-zero --buffer-retention")
+	}
+	if _, err := overflowPolicy(cmd); err != nil {
+		return nil, err
+	}
+
+	opts := []slackio.ClientOption{
+		slackio.WithQueueSize(bufferSize),
+		slackio.WithRetention(bufferRetention),
+		slackio.WithErrorHandler(func(err error) {
+			fmt.Fprintf(os.Stderr, "slackbridge: %v\n", err)
+		}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clientSetupTimeout)
+	defer cancel()
+
+	switch receiveVia {
+	case "rtm":
+		return slackio.NewClientContext(ctx, apiToken, opts...)
+
+	case "socket":
+		appToken := os.Getenv(appTokenVar)
+		if appToken == "" {
+			return nil, fmt.Errorf("%s environment variable not set (required for --receive-via=socket)", appTokenVar)
+		}
+		return slackio.NewSocketModeClientContext(ctx, appToken, apiToken, opts...)
+
+	case "events":
+		signingSecret := os.Getenv("SLACK_SIGNING_SECRET")
+		if signingSecret == "" {
+			return nil, fmt.Errorf("SLACK_SIGNING_SECRET environment variable not set (required for --receive-via=events)")
+		}
+
+		addr, _ := flags.GetString("listen")
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return slackio.NewEventsAPIClientContext(ctx, l, signingSecret, apiToken, opts...)
+
+	default:
+		return nil, fmt.Errorf("unknown --receive-via value %q", receiveVia)
+	}
+}
+
+// overflowPolicy returns the slackio.OverflowPolicy selected by the --overflow
+// flag.
+func overflowPolicy(cmd *cobra.Command) (slackio.OverflowPolicy, error) {
+	overflow, _ := cmd.Flags().GetString("overflow")
+	switch overflow {
+	case "skip":
+		return slackio.OverflowSkip, nil
+	case "block":
+		return slackio.OverflowBlock, nil
+	case "report":
+		return slackio.OverflowReport, nil
+	default:
+		return 0, fmt.Errorf("unknown --overflow value %q", overflow)
+	}
+}
+
+// subscriber implements the slackio.ReadClient interface, but starts the
+// subscription at a specified message ID (or the latest message, if negative)
+// using the overflow options selected by the flags added through addInputFlags.
+type subscriber struct {
+	*slackio.Client
+	id   int
+	opts slackio.SubscribeOptions
+}
+
+// newSubscriber returns a subscriber for the given Client. If messages are lost
+// because the subscriber falls behind (with --overflow=block or
+// --overflow=report), a warning is printed to stderr. If channelID is not
+// blank, only messages lost from that channel are reported, and the warning is
+// sent to the channel as well if writeClient is non-nil.
+func newSubscriber(cmd *cobra.Command, client *slackio.Client, id int, channelID string, writeClient slackio.WriteClient) *subscriber {
+	// newClient has already validated the flag for us.
+	policy, _ := overflowPolicy(cmd)
+	maxBlock, _ := cmd.Flags().GetDuration("overflow-max-block")
+
+	return &subscriber{
+		Client: client,
+		id:     id,
+		opts: slackio.SubscribeOptions{
+			Overflow: policy,
+			MaxBlock: maxBlock,
+			OnDrop: func(lost map[string]int) {
+				n := lostCount(lost, channelID)
+				if n == 0 {
+					return
+				}
+
+				fmt.Fprintf(os.Stderr, "slackbridge: %d message(s) lost because the reader fell behind\n", n)
+
+				if writeClient != nil && channelID != "" {
+					writeClient.SendMessage(slackio.Message{
+						ChannelID: channelID,
+						Text:      "_slackbridge: some messages may have been lost because this program fell behind_",
+					})
+				}
+			},
+		},
+	}
+}
+
+// lostCount returns the number of messages in lost (as passed to an OnDrop
+// function) that may have come from channelID, or from any channel if channelID
+// is blank.
+func lostCount(lost map[string]int, channelID string) int {
+	if channelID != "" {
+		return lost[channelID] + lost[""]
+	}
+
+	var n int
+	for _, count := range lost {
+		n += count
+	}
+	return n
+}
+
+func (s *subscriber) Subscribe(ch chan<- slackio.Message) error {
+	return s.SubscribeWithOptions(s.id, ch, s.opts)
+}
@@ -32,5 +32,13 @@ following locations:
   go-multierror: https://github.com/hashicorp/go-multierror
   errwrap: https://github.com/hashicorp/errwrap
 
+It also incorporates the yaml.v2 library by Canonical Ltd., under the terms of
+the Apache License, version 2.0, and the MIT License for portions ported from
+libyaml. Its source code, licenses, and notices are available to you at the
+above location under the "vendor/gopkg.in/yaml.v2/" directory, or at the
+following location:
+
+  yaml.v2: https://github.com/go-yaml/yaml/tree/v2
+
 `)
 }
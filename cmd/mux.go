@@ -3,17 +3,19 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"regexp"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/nlopes/slack"
 	"github.com/spf13/cobra"
-	"go.alexhamlin.co/slackio"
 
-	"go.alexhamlin.co/slackbridge/internal/childproc"
+	"go.alexhamlin.co/slackbridge/internal/directory"
 )
 
 var muxCmd = &cobra.Command{
-	Use:     "mux [flags] -- program [args]",
-	Example: "mux -- ./start-bot.sh -c {{.ChannelID}}",
+	Use:     "mux [flags] [-- program [args]]",
+	Example: "mux -- ./start-bot.sh -c {{.ChannelID}}\n  mux --env 'DATA_DIR=/var/lib/bot/{{.Slug}}' -- ./bot\n  mux --route 'type:im=./dm-bot' --route '#deploy-*=./deploy-bot {{.ChannelID}}'",
 	Short:   "Exec a separate instance of a program per Slack channel",
 	Long: `Mux spawns multiple instances of a provided executable program, where the
 standard input, output, and error streams of each instance are connected to a
@@ -22,25 +24,97 @@ Exec mode - this is essentially an Exec mode with automatic support for
 multiple channels.
 
 Child processes are spawned on demand when a message is received from a channel
-that does not yet have an associated process. The special pattern
-"{{.ChannelID}}" will be replaced with the ID of the channel in the arguments
-of the spawned process (note that this is a subset of Go's template syntax -
-full templating functionality is not supported).
-
-If a child process exits, slackbridge will not automatically respawn it. This
-can be used as a basic "filter" to restrict slackbridge to a subset of the
-associated user's channels.`,
-
-	Args: cobra.MinimumNArgs(1),
+that does not yet have an associated process. Each argument of the spawned
+process, each --env value, and the --dir working directory is a Go template
+(see https://golang.org/pkg/text/template/) with the following fields:
+
+  .ChannelID    the ID of the channel
+  .ChannelName  the name of the channel, or its ID if it can't be resolved
+  .ChannelType  public, private, im, or mpim (blank if unknown)
+  .TeamID       the ID of the workspace
+  .UserID       the ID of the user who sent the first message
+  .Slug         the channel name reduced to lowercase letters, digits, '.',
+                '_', and '-', suitable for file names
+
+For example, --dir '/srv/bot/{{.Slug}}' gives each channel its own directory
+(which must already exist).
+
+The channels that are handled at all can be restricted with --only-channel,
+--skip-channel, --only-type (public, private, im, or mpim), and --only-name and
+--skip-name (globs matched against channel names, where direct messages are
+named after the other user, prefixed with "@"). Messages from other channels
+are ignored without spawning a child process. Each kind of filter that is
+provided must match for a channel to be handled.
+
+By default, if a child process exits, slackbridge will not respawn it. With
+--respawn, a new child process is started on the next message from the
+channel. A child that exits within --crash-window of starting is
+considered to have crashed: it is respawned after --respawn-backoff, doubling
+with each consecutive crash, and after --crash-limit consecutive crashes it is
+no longer respawned at all.
+
+With --idle-timeout, child processes whose channels go without messages for
+the given time are stopped, and started again on the channel's next message
+(whether or not --respawn is given).
+
+Slackbridge keeps track of the child process of each channel. Starts, exits,
+and failures to start are logged to stderr, and with --report-exits are also
+posted to the affected channel. On SIGUSR1 (except on Windows), the state of
+every channel's child is logged. With --admin, children can also be inspected
+and controlled using "slackbridge ctl". The admin API is served on a Unix socket
+or a loopback address, unless SLACKBRIDGE_ADMIN_TOKEN is set to a token that
+every request must then present. On SIGINT or SIGTERM, every child is asked to
+exit (and killed if it does not within 10 seconds) before slackbridge exits.
+
+With --max-children, at most the given number of child processes run at once.
+When another is needed, --at-capacity selects whether to stop the child whose
+channel was least recently active (to be started again on its next message),
+to queue the new channel until a child exits, or to refuse it. With eviction,
+the new channel's child starts once the evicted child has exited, so the limit
+holds even while children take time to stop. Queued and refused channels are
+notified in Slack.
+
+Messages that arrive while a channel's child is waiting to start (whether
+queued or backing off after a crash) are buffered for the channel, and passed
+to the child once it starts. At most --startup-buffer messages are buffered for
+each channel; further messages are dropped, with a notice in the channel. Once
+a child has started, messages are buffered for it according to --buffer-size
+and --overflow as usual. --overflow also applies to mux itself as it hands
+messages off to children; with --overflow=block, no message is lost before a
+child starts.
+
+Different channels can run different programs using routes, given with
+--route PATTERN=COMMAND or one per line in --routes-file. When a message is
+received from a channel without a child process, the routes are evaluated in
+order and the first match selects the command line to run, which is split into
+words as by a shell (without expansions, so templates containing spaces must
+be quoted). Channels matching no route run the program given as arguments, or
+are ignored if none is given. An empty COMMAND ignores matching channels.
+Ignored channels are reconsidered on each message. The pattern ends at the
+first '=', so an '=' within a text: pattern must be written as '\='. Patterns
+take the following forms:
+
+  C12345678, id:C12345678  the channel with the given ID
+  #deploy-*, name:deploy-* channels whose names match a glob (direct messages
+                           are named after the other user, prefixed with "@")
+  type:im                  channels of a type: public, private, im, or mpim
+  text:REGEX               channels whose triggering message matches REGEX
+  *                        any channel`,
+
+	Args: cobra.ArbitraryArgs,
 	Run:  runMuxCmd,
 }
 
-var channelIDTemplate *regexp.Regexp
-
 func init() {
 	RootCmd.AddCommand(muxCmd)
-
-	channelIDTemplate = regexp.MustCompile(`{{\.ChannelID}}`)
+	addInputFlags(muxCmd)
+	addOutputFlags(muxCmd)
+	addCheckpointFlags(muxCmd)
+	addRouteFlags(muxCmd)
+	addMuxFilterFlags(muxCmd)
+	addMuxChildFlags(muxCmd)
+	addMuxPolicyFlags(muxCmd)
+	addAdminFlags(muxCmd)
 }
 
 func runMuxCmd(cmd *cobra.Command, args []string) {
@@ -51,39 +125,101 @@ func runMuxCmd(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	client := slackio.NewClient(apiToken)
+	readerOpts, err := readerOptions(cmd, apiToken, false)
+	if err != nil {
+		exitWithError(err)
+	}
 
-	msgs := make(chan slackio.Message)
-	client.Subscribe(msgs)
+	router, err := newMuxRouter(cmd, directory.New(slack.New(apiToken)), args)
+	if err != nil {
+		exitWithError(err)
+	}
 
-	spawned := make(map[string]bool)
+	childSpec, err := muxChildSpecFromFlags(cmd)
+	if err != nil {
+		exitWithError(err)
+	}
 
-	for msg := range msgs {
-		if spawned[msg.ChannelID] {
-			continue
-		}
+	policy, err := muxPolicyFromFlags(cmd)
+	if err != nil {
+		exitWithError(err)
+	}
 
-		childArgs := make([]string, len(args))
-		for i, v := range args {
-			childArgs[i] = channelIDTemplate.ReplaceAllString(v, msg.ChannelID)
-		}
+	store, err := openCheckpoints(cmd)
+	if err != nil {
+		exitWithError(err)
+	}
 
-		reader := slackio.NewReader(&subscriberAt{client, msg.ID}, msg.ChannelID)
-		writer := slackio.NewWriter(client, msg.ChannelID, nil)
+	onlyChannels, _ := cmd.Flags().GetStringSlice("only-channel")
+	positions, err := replayPositions(cmd, apiToken, store, onlyChannels)
+	if err != nil {
+		exitWithError(err)
+	}
 
-		// TODO Something other than fire-and-forget...
-		childproc.Spawn(childArgs, reader, writer)
-		spawned[msg.ChannelID] = true
+	client, err := newClient(cmd, apiToken)
+	if err != nil {
+		exitWithError(err)
 	}
-}
 
-// subscriberAt implements the slackio.ReadClient interface, but starts the
-// subscription at a specified message ID using SubscribeAt.
-type subscriberAt struct {
-	*slackio.Client
-	id int
+	writeClient, err := newWriteClient(cmd, apiToken, client)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	// Register for signals before starting any children, so that none are left
+	// behind by an early SIGTERM.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, statusSignals...)...)
+
+	m := newMuxer(cmd, client, writeClient, store, readerOpts, router, childSpec, policy)
+	admin, err := startAdminServer(cmd, func() []adminBridge {
+		return []adminBridge{{name: "mux", mode: "mux", children: m}}
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+	m.start()
+
+	go replay(client, positions)
+
+loop:
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+				break loop
+			}
+			logMuxChildren(m.list())
+
+		case <-client.Failed():
+			break loop
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "slackbridge: shutting down")
+	admin.Close()
+	m.stop()
+	client.Close()
+
+	if err := client.Err(); err != nil {
+		exitWithError(err)
+	}
 }
 
-func (s *subscriberAt) Subscribe(ch chan<- slackio.Message) error {
-	return s.SubscribeAt(s.id, ch)
+// logMuxChildren writes a line to stderr for each of the given children.
+func logMuxChildren(infos []childInfo) {
+	fmt.Fprintf(os.Stderr, "slackbridge: %d channel(s) with child processes\n", len(infos))
+	for _, info := range infos {
+		line := fmt.Sprintf("slackbridge:   %s %s", info.ChannelID, info.State)
+		if info.PID != 0 {
+			line += fmt.Sprintf(" pid=%d started=%s", info.PID, info.Started.Format(time.RFC3339))
+		}
+		if info.ExitCode >= 0 {
+			line += fmt.Sprintf(" status=%d", info.ExitCode)
+		}
+		if info.Crashes > 0 {
+			line += fmt.Sprintf(" crashes=%d", info.Crashes)
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
 }
@@ -0,0 +1,1002 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/checkpoint"
+	"go.alexhamlin.co/slackbridge/internal/childproc"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// childStopTimeout is how long a child process is given to exit after being
+// asked to terminate, before it is killed.
+const childStopTimeout = 10 * time.Second
+
+// muxTickInterval is how often a muxer checks for idle children and for
+// respawns whose backoff has elapsed.
+const muxTickInterval = 500 * time.Millisecond
+
+// muxChildSpec describes the environment and working directory of a muxer's
+// child processes. Both the values in Env and Dir are templates, executed with
+// a childTemplateData for each child.
+type muxChildSpec struct {
+	Env []string // KEY=VALUE pairs added to slackbridge's own environment
+	Dir string   // blank for slackbridge's own working directory
+}
+
+// addMuxChildFlags adds flags to the given command that select a muxChildSpec.
+func addMuxChildFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringArray("env", nil, "set KEY=VALUE in the environment of each child process, where VALUE is a template like the arguments (repeatable)")
+	flags.String("dir", "", "working directory of each child process, as a template like the arguments")
+}
+
+// muxChildSpecFromFlags returns the muxChildSpec selected by the flags added
+// through addMuxChildFlags.
+func muxChildSpecFromFlags(cmd *cobra.Command) (muxChildSpec, error) {
+	var spec muxChildSpec
+	spec.Env, _ = cmd.Flags().GetStringArray("env")
+	spec.Dir, _ = cmd.Flags().GetString("dir")
+	return spec, spec.check()
+}
+
+// check reports errors in the spec's templates and environment variables.
+func (s muxChildSpec) check() error {
+	for _, kv := range s.Env {
+		if i := strings.IndexByte(kv, '='); i <= 0 {
+			return fmt.Errorf("invalid environment variable %q: expected KEY=VALUE", kv)
+		}
+	}
+	return checkChildTemplates(append(s.Env, s.Dir)...)
+}
+
+// expand executes the templates of the given command line and of the spec,
+// and returns the resulting command line and options for a child process.
+func (s muxChildSpec) expand(command []string, data childTemplateData) ([]string, childproc.Options, error) {
+	var opts childproc.Options
+
+	args := make([]string, len(command))
+	for i, v := range command {
+		var err error
+		if args[i], err = executeChildTemplate(v, data); err != nil {
+			return nil, opts, err
+		}
+	}
+
+	if len(s.Env) > 0 {
+		// Later entries override earlier ones with the same key.
+		opts.Env = os.Environ()
+		for _, kv := range s.Env {
+			value, err := executeChildTemplate(kv, data)
+			if err != nil {
+				return nil, opts, err
+			}
+			opts.Env = append(opts.Env, value)
+		}
+	}
+
+	var err error
+	opts.Dir, err = executeChildTemplate(s.Dir, data)
+	return args, opts, err
+}
+
+// muxPolicy controls when a muxer respawns and reaps child processes. The zero
+// value never respawns or reaps children.
+type muxPolicy struct {
+	// Respawn enables the respawning of a channel's child process on the next
+	// message after it exits.
+	Respawn bool
+
+	// RespawnOnlyFailures limits respawning to children that failed to start
+	// or exited with a nonzero status.
+	RespawnOnlyFailures bool
+
+	// Backoff is the delay before respawning a child that crashed (i.e. exited
+	// within CrashWindow of starting), doubling with each consecutive crash up
+	// to MaxBackoff.
+	Backoff, MaxBackoff time.Duration
+
+	// CrashWindow and CrashLimit detect crash loops: after CrashLimit
+	// consecutive crashes, a channel's child is no longer respawned.
+	CrashWindow time.Duration
+	CrashLimit  int
+
+	// IdleTimeout, if positive, is how long a child may go without a message
+	// from its channel before it is stopped. Stopped children are started
+	// again on the next message, regardless of Respawn.
+	IdleTimeout time.Duration
+
+	// MaxChildren, if positive, limits the number of running children,
+	// including those being stopped. When a new child is needed at the limit,
+	// AtCapacity is one of "evict" (stop the least recently active child, and
+	// start the new one once it exits), "queue" (wait for a child to exit), or
+	// "refuse" (ignore the message).
+	MaxChildren int
+	AtCapacity  string
+
+	// ReportExits posts a notice in a channel when its child fails to start
+	// or exits on its own, in addition to logging it.
+	ReportExits bool
+
+	// StartupBuffer, if positive, limits the number of messages buffered for
+	// each channel while its child is waiting to start (e.g. while queued or
+	// backing off). Further messages are dropped with a notice.
+	StartupBuffer int
+}
+
+// addMuxPolicyFlags adds flags to the given command that select a muxPolicy.
+func addMuxPolicyFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.Bool("respawn", false, "start a new child process on the next message from a channel whose child has exited")
+	flags.Duration("respawn-backoff", time.Second, "with --respawn, delay before respawning a child that crashed, doubling with each consecutive crash")
+	flags.Duration("respawn-max-backoff", 5*time.Minute, "with --respawn, maximum delay before respawning a child that crashed (0 for no limit)")
+	flags.Duration("crash-window", 10*time.Second, "with --respawn, a child that exits within this long of starting is considered to have crashed")
+	flags.Int("crash-limit", 5, "with --respawn, stop respawning a channel's child after this many consecutive crashes (0 for no limit)")
+	flags.Duration("idle-timeout", 0, "stop child processes whose channels have been idle this long, and start them again on the next message (0 to never stop them)")
+	flags.Int("max-children", 0, "maximum number of child processes to run at once (0 for no limit)")
+	flags.Int("startup-buffer", 1000, "maximum number of messages to buffer for each channel while its child process is waiting to start (0 for no limit)")
+	flags.Bool("report-exits", false, "post a notice in a channel when its child process fails to start or exits")
+	flags.String("at-capacity", "evict", `behavior when a new child process is needed at --max-children: "evict" (stop the least recently active child, then start the new one), "queue" (wait for a child to exit), or "refuse" (ignore the channel's message)`)
+}
+
+// muxPolicyFromFlags returns the muxPolicy selected by the flags added through
+// addMuxPolicyFlags.
+func muxPolicyFromFlags(cmd *cobra.Command) (muxPolicy, error) {
+	flags := cmd.Flags()
+
+	var p muxPolicy
+	p.Respawn, _ = flags.GetBool("respawn")
+	p.Backoff, _ = flags.GetDuration("respawn-backoff")
+	p.MaxBackoff, _ = flags.GetDuration("respawn-max-backoff")
+	p.CrashWindow, _ = flags.GetDuration("crash-window")
+	p.CrashLimit, _ = flags.GetInt("crash-limit")
+	p.IdleTimeout, _ = flags.GetDuration("idle-timeout")
+	p.MaxChildren, _ = flags.GetInt("max-children")
+	p.AtCapacity, _ = flags.GetString("at-capacity")
+	p.ReportExits, _ = flags.GetBool("report-exits")
+	p.StartupBuffer, _ = flags.GetInt("startup-buffer")
+
+	switch p.AtCapacity {
+	case "evict", "queue", "refuse":
+	default:
+		return p, fmt.Errorf("unknown --at-capacity value %q", p.AtCapacity)
+	}
+	if p.MaxChildren < 0 {
+		return p, errors.New("--max-children cannot be negative")
+	}
+	if p.StartupBuffer < 0 {
+		return p, errors.New("--startup-buffer cannot be negative")
+	}
+	return p, nil
+}
+
+// respawns reports whether the policy respawns the given child, which has
+// exited or failed to start.
+func (p muxPolicy) respawns(child *muxChild) bool {
+	return p.Respawn && (!p.RespawnOnlyFailures || child.failed || child.exitCode != 0)
+}
+
+// maxDuration is the longest time.Duration.
+const maxDuration = time.Duration(1<<63 - 1)
+
+// backoff returns the delay before respawning a child after the given number
+// of consecutive crashes. If MaxBackoff is 0, the delay keeps doubling.
+func (p muxPolicy) backoff(crashes int) time.Duration {
+	if crashes == 0 {
+		return 0
+	}
+
+	d := p.Backoff
+	for i := 1; i < crashes && d > 0 && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		if d > maxDuration/2 {
+			return maxDuration
+		}
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// muxChild tracks the child process of a single channel. It is only accessed
+// by the muxer's run goroutine.
+type muxChild struct {
+	channelID string
+	proc      *childproc.Process // nil if not running
+	pid       int                // of the current or last process
+	started   time.Time
+	active    time.Time // when the last message from the channel was received
+	exitCode  int       // of the last process, if not running
+	failed    bool      // whether the last attempt to start a process failed
+
+	first      slackio.Message // the message that started the current process
+	stream     *childStream    // of the current or last process
+	paused     bool            // whether input is paused, even across restarts
+	restarting bool            // whether proc is being stopped to start again
+
+	reaped  bool // whether proc was stopped for being idle
+	crashes int  // consecutive crashes
+	gaveUp  bool // whether a crash loop was detected
+	retryAt time.Time
+	pending *slackio.Message // the first message awaiting a respawn
+}
+
+// Child states, as reported by muxChild.state.
+const (
+	childRunning  = "running"
+	childStopping = "stopping" // being stopped by slackbridge
+	childStopped  = "stopped"  // stopped by slackbridge, to start on the next message
+	childExited   = "exited"
+	childFailed   = "failed" // could not be started
+	childBackoff  = "backoff"
+	childGaveUp   = "gave-up"
+	childQueued   = "queued"
+)
+
+func (c *muxChild) state(now time.Time) string {
+	switch {
+	case c.proc != nil && c.reaped:
+		return childStopping
+	case c.proc != nil:
+		return childRunning
+	case c.gaveUp:
+		return childGaveUp
+	case now.Before(c.retryAt):
+		return childBackoff
+	case c.reaped:
+		return childStopped
+	case c.failed:
+		return childFailed
+	default:
+		return childExited
+	}
+}
+
+// childInfo describes the child process of a channel, as reported by the list
+// method of a childController.
+type childInfo struct {
+	ChannelID string
+	PID       int // 0 if the child was never started
+	State     string
+	Started   time.Time // zero if the child was never started
+	ExitCode  int       // -1 if running, or if not known
+	Crashes   int
+
+	BytesIn, BytesOut int64     // of the current or last process
+	LastActivity      time.Time // the last message, input, or output
+	Paused            bool
+}
+
+// muxExit reports the exit of a child process.
+type muxExit struct {
+	child *muxChild
+	proc  *childproc.Process
+	code  int
+}
+
+// muxer spawns a child process for each Slack channel from which a message is
+// received, as in mux mode.
+type muxer struct {
+	cmd         *cobra.Command
+	client      *slackio.Client
+	writeClient slackio.WriteClient
+	store       *checkpoint.Store
+	readerOpts  slackio.ReaderOptions
+	router      *muxRouter
+	childSpec   muxChildSpec
+	policy      muxPolicy
+
+	msgs     chan slackio.Message
+	exits    chan muxExit
+	resolved chan muxResolution
+	requests chan func() // run within the muxer's goroutine
+	done     chan struct{}
+
+	children map[string]*muxChild
+
+	// Channels whose routes and template data are being resolved, which may
+	// require Slack API calls, outside of the muxer's goroutine. Their messages
+	// are buffered in the meantime.
+	resolving map[string]bool
+
+	// Messages from channels whose children are waiting to start, which are
+	// delivered to the children once they do, and the ID of the last message
+	// received from any channel, after which their Readers subscribe.
+	buffers map[string]*muxBuffer
+	lastID  int
+
+	// Channels waiting for capacity (with AtCapacity "queue", or "evict" while
+	// the evicted children exit), and the message with which each will start.
+	// Channels taken from the queue, and those whose children are restarting,
+	// are admitted, reserving capacity for their children while their routes are
+	// resolved.
+	queue    []string
+	queued   map[string]slackio.Message
+	admitted map[string]bool
+
+	// Channels that have been told that they were refused with AtCapacity
+	// "refuse", so that they are only told once.
+	refused map[string]bool
+
+	// The clock, and the means of resolving routes and starting child
+	// processes, which tests replace.
+	now        func() time.Time
+	resolve    func(msg slackio.Message) muxResolution
+	startChild func(channelID string, args []string, opts childproc.Options, buffered []slackio.Message, stream *childStream) (*childproc.Process, error)
+
+	stopOnce sync.Once
+}
+
+func newMuxer(cmd *cobra.Command, client *slackio.Client, writeClient slackio.WriteClient, store *checkpoint.Store, readerOpts slackio.ReaderOptions, router *muxRouter, childSpec muxChildSpec, policy muxPolicy) *muxer {
+	m := &muxer{
+		cmd:         cmd,
+		client:      client,
+		writeClient: writeClient,
+		store:       store,
+		readerOpts:  readerOpts,
+		router:      router,
+		childSpec:   childSpec,
+		policy:      policy,
+		msgs:        make(chan slackio.Message),
+		exits:       make(chan muxExit),
+		resolved:    make(chan muxResolution),
+		requests:    make(chan func()),
+		done:        make(chan struct{}),
+		children:    make(map[string]*muxChild),
+		resolving:   make(map[string]bool),
+		buffers:     make(map[string]*muxBuffer),
+		queued:      make(map[string]slackio.Message),
+		admitted:    make(map[string]bool),
+		refused:     make(map[string]bool),
+		now:         time.Now,
+	}
+	m.resolve, m.startChild = m.resolveRoute, m.startChildProcess
+	return m
+}
+
+// start subscribes the muxer to its Client, and spawns child processes until
+// stop is called.
+//
+// The muxer subscribes using the --overflow and --overflow-max-block flags,
+// like any Reader. With --overflow=block, it sees every message: those for
+// channels whose children are waiting to start are buffered, and the rest
+// remain in the Client's queue until the children's Readers subscribe after
+// them. As the muxer only hands messages off, and does anything slow (such as
+// looking up channels or posting notices) in other goroutines, it blocks the
+// Client only briefly (e.g. while spawning a child).
+func (m *muxer) start() {
+	policy, _ := overflowPolicy(m.cmd)
+	maxBlock, _ := m.cmd.Flags().GetDuration("overflow-max-block")
+
+	m.client.SubscribeWithOptions(-1, m.msgs, slackio.SubscribeOptions{
+		Overflow: policy,
+		MaxBlock: maxBlock,
+		OnDrop: func(lost map[string]int) {
+			fmt.Fprintf(os.Stderr, "slackbridge: %d message(s) lost because mux fell behind\n", lostCount(lost, ""))
+		},
+	})
+	go m.run()
+}
+
+func (m *muxer) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(muxTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-m.msgs:
+			if !ok {
+				return
+			}
+			m.receive(msg)
+
+		case exit := <-m.exits:
+			m.exited(exit)
+
+		case res := <-m.resolved:
+			m.spawnResolved(res)
+
+		case req := <-m.requests:
+			req()
+
+		case <-ticker.C:
+			m.tick(m.now())
+		}
+	}
+}
+
+// receive handles a message received from the Client.
+func (m *muxer) receive(msg slackio.Message) {
+	m.lastID = msg.ID
+	m.handle(msg)
+}
+
+// handle starts or buffers input for the channel of a message, if necessary.
+func (m *muxer) handle(msg slackio.Message) {
+	if msg.ThreadTimestamp != "" && !m.readerOpts.IncludeThreads {
+		return
+	}
+
+	child := m.children[msg.ChannelID]
+	if child != nil {
+		child.active = m.now()
+	}
+
+	if buf := m.buffers[msg.ChannelID]; buf != nil {
+		m.buffer(buf, msg)
+		return
+	}
+
+	if child != nil {
+		switch {
+		case child.proc != nil || child.gaveUp:
+			return
+		case !child.reaped && !m.policy.respawns(child):
+			// Without --respawn, a channel whose child exited stays silent.
+			return
+		case m.now().Before(child.retryAt):
+			child.pending = &msg
+			m.startBuffer(msg)
+			return
+		}
+	}
+
+	m.spawn(msg.ChannelID, msg)
+}
+
+// muxResolution is the command line and template data for a channel's child
+// process, resolved outside of the muxer's goroutine. command is nil if the
+// channel has no route.
+type muxResolution struct {
+	msg     slackio.Message
+	command []string
+	data    childTemplateData
+}
+
+// spawn starts a child process for the given channel, with its input starting
+// at the given message. The channel's route and template data are first
+// resolved in another goroutine, as they may require Slack API calls, and its
+// messages are buffered until spawnResolved starts the child.
+func (m *muxer) spawn(channelID string, msg slackio.Message) {
+	if child := m.children[channelID]; child != nil {
+		child.pending = nil
+	}
+	if m.resolving[channelID] {
+		return
+	}
+
+	m.resolving[channelID] = true
+	if m.buffers[channelID] == nil {
+		if msg.ID < 0 {
+			m.buffers[channelID] = &muxBuffer{limit: m.policy.StartupBuffer}
+		} else {
+			m.startBuffer(msg)
+		}
+	}
+
+	go func() {
+		res := m.resolve(msg)
+		select {
+		case m.resolved <- res:
+		case <-m.done:
+		}
+	}()
+}
+
+// resolveRoute returns the command line and template data for the channel of
+// the given message.
+func (m *muxer) resolveRoute(msg slackio.Message) muxResolution {
+	res := muxResolution{msg: msg, command: m.router.route(msg)}
+	if res.command != nil {
+		res.data = newChildTemplateData(msg, m.teamID(), m.router.dir)
+	}
+	return res
+}
+
+// spawnResolved starts a child process for a channel whose route and template
+// data have been resolved. Channels without a route are reconsidered on each
+// message, as routes may match on the text of a message.
+func (m *muxer) spawnResolved(res muxResolution) {
+	channelID, msg, command := res.msg.ChannelID, res.msg, res.command
+	delete(m.resolving, channelID)
+
+	admitted := m.admitted[channelID]
+	if admitted {
+		// Whether or not the child starts, the capacity reserved for it is
+		// released, and the queue may move on.
+		delete(m.admitted, channelID)
+		defer m.dequeue()
+	}
+
+	if command == nil {
+		buf := m.buffers[channelID]
+		delete(m.buffers, channelID)
+		if buf != nil {
+			for _, bm := range buf.msgs {
+				if bm.ID != msg.ID {
+					m.handle(bm)
+				}
+			}
+		}
+		return
+	}
+
+	if m.policy.MaxChildren > 0 && !admitted && m.running()+len(m.admitted) >= m.policy.MaxChildren && !m.makeRoom(channelID, msg) {
+		return
+	}
+	delete(m.refused, channelID)
+	buffered := m.takeBuffer(channelID, msg)
+
+	child := m.children[channelID]
+	if child == nil {
+		child = &muxChild{channelID: channelID, exitCode: -1}
+		m.children[channelID] = child
+	}
+	child.reaped = false
+
+	var proc *childproc.Process
+	stream := newChildStream(child.paused)
+	args, opts, err := m.childSpec.expand(command, res.data)
+	if err == nil {
+		proc, err = m.startChild(channelID, args, opts, buffered, stream)
+	}
+	if err != nil {
+		child.failed, child.exitCode = true, -1
+		fmt.Fprintf(os.Stderr, "slackbridge: failed to start child process for %s: %v\n", channelID, err)
+		m.report(channelID, "_slackbridge: the program for this channel could not be started_")
+		if m.policy.respawns(child) {
+			m.crashed(child)
+		}
+		return
+	}
+
+	child.proc, child.pid, child.failed = proc, proc.Pid(), false
+	child.first, child.stream = msg, stream
+	child.started = m.now()
+	child.active = child.started
+	fmt.Fprintf(os.Stderr, "slackbridge: started child process %d for %s (%s)\n", proc.Pid(), channelID, m.childCount())
+
+	go func() {
+		code := proc.ExitCode()
+		select {
+		case m.exits <- muxExit{child: child, proc: proc, code: code}:
+		case <-m.done:
+		}
+	}()
+}
+
+// startChildProcess starts a child process connected to a channel, with its
+// input starting with the buffered messages, followed by those received after
+// the last message that the muxer has seen.
+func (m *muxer) startChildProcess(channelID string, args []string, opts childproc.Options, buffered []slackio.Message, stream *childStream) (*childproc.Process, error) {
+	feed := newMuxFeed(newSubscriber(m.cmd, m.client, m.lastID+1, channelID, m.writeClient), buffered)
+	reader := stream.input(slackio.NewReaderWithOptions(feed, channelID, checkpointReaderOptions(m.readerOpts, m.store)))
+	writer := stream.output(slackio.NewWriter(m.writeClient, channelID, nil))
+	proc, err := childproc.SpawnWithOptions(args, reader, writer, opts)
+	if err != nil {
+		reader.Close()
+		writer.Close()
+	}
+	return proc, err
+}
+
+// startBuffer starts buffering the messages of a channel whose child is
+// waiting to start, beginning with msg.
+func (m *muxer) startBuffer(msg slackio.Message) {
+	buf := &muxBuffer{limit: m.policy.StartupBuffer}
+	m.buffers[msg.ChannelID] = buf
+	m.buffer(buf, msg)
+}
+
+func (m *muxer) buffer(buf *muxBuffer, msg slackio.Message) {
+	if buf.add(msg) || buf.dropped > 1 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "slackbridge: dropping messages from %s, as %d are already buffered for its child process\n", msg.ChannelID, len(buf.msgs))
+	m.notify(msg.ChannelID, "_slackbridge: some messages were dropped because too many arrived while the program for this channel was waiting to start_")
+}
+
+// takeBuffer stops buffering the messages of a channel, and returns those with
+// which its child's input should start: the buffered messages, or if none were
+// buffered, msg itself (unless it has a negative ID, as with restarts).
+func (m *muxer) takeBuffer(channelID string, msg slackio.Message) []slackio.Message {
+	buf := m.buffers[channelID]
+	if buf == nil {
+		if msg.ID < 0 {
+			return nil
+		}
+		return []slackio.Message{msg}
+	}
+
+	delete(m.buffers, channelID)
+	if buf.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "slackbridge: %d message(s) from %s were dropped while its child process was waiting to start\n", buf.dropped, channelID)
+	}
+	return buf.msgs
+}
+
+// teamID returns the ID of the team to which the muxer's client is connected,
+// or a blank string if it can't be determined.
+func (m *muxer) teamID() string {
+	self, err := m.client.Identity()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "slackbridge: failed to determine team ID: %v\n", err)
+	}
+	return self.TeamID
+}
+
+// exited handles the exit of a child process.
+func (m *muxer) exited(exit muxExit) {
+	child := exit.child
+	if child.proc != exit.proc {
+		return
+	}
+	child.proc, child.exitCode = nil, exit.code
+	defer m.dequeue()
+
+	if child.reaped {
+		fmt.Fprintf(os.Stderr, "slackbridge: child process %d for %s stopped (%s)\n", exit.proc.Pid(), child.channelID, m.childCount())
+		if child.restarting {
+			// The child's capacity is reserved for its replacement, so that
+			// dequeue doesn't give it to a queued channel in the meantime.
+			child.restarting = false
+			m.admitted[child.channelID] = true
+			m.respawn(child)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "slackbridge: child process %d for %s exited with status %d (%s)\n", exit.proc.Pid(), child.channelID, exit.code, m.childCount())
+	m.report(child.channelID, fmt.Sprintf("_slackbridge: the program for this channel exited with status %d_", exit.code))
+	if !m.policy.respawns(child) {
+		return
+	}
+
+	if m.now().Sub(child.started) < m.policy.CrashWindow {
+		m.crashed(child)
+	} else {
+		child.crashes = 0
+		child.retryAt = time.Time{}
+	}
+}
+
+// crashed records a crash of the given channel's child, and schedules its
+// respawn or gives up on it.
+func (m *muxer) crashed(child *muxChild) {
+	child.crashes++
+	if m.policy.CrashLimit > 0 && child.crashes >= m.policy.CrashLimit {
+		child.gaveUp = true
+		fmt.Fprintf(os.Stderr, "slackbridge: child process for %s crashed %d times in a row; no longer respawning it\n", child.channelID, child.crashes)
+		m.notify(child.channelID, "_slackbridge: the program for this channel keeps crashing, so it will not be restarted_")
+		return
+	}
+
+	backoff := m.policy.backoff(child.crashes)
+	child.retryAt = m.now().Add(backoff)
+	fmt.Fprintf(os.Stderr, "slackbridge: child process for %s will be respawned on the next message after %v\n", child.channelID, backoff)
+}
+
+// tick reaps idle children, and respawns children whose backoff has elapsed
+// since a message was received.
+func (m *muxer) tick(now time.Time) {
+	for _, child := range m.children {
+		if child.proc == nil && child.pending != nil && !now.Before(child.retryAt) {
+			m.spawn(child.channelID, *child.pending)
+			continue
+		}
+
+		if m.policy.IdleTimeout > 0 && child.proc != nil && !child.reaped && now.Sub(child.active) >= m.policy.IdleTimeout {
+			fmt.Fprintf(os.Stderr, "slackbridge: stopping child process %d for %s after %v without messages\n", child.proc.Pid(), child.channelID, m.policy.IdleTimeout)
+			child.reaped = true
+			go terminateChild(child.proc)
+		}
+	}
+}
+
+// running returns the number of running children, including those that are
+// being stopped, which count against MaxChildren until they exit.
+func (m *muxer) running() int {
+	n := 0
+	for _, child := range m.children {
+		if child.proc != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// stopping returns the number of children that are being stopped, excluding
+// those that will immediately be started again.
+func (m *muxer) stopping() int {
+	n := 0
+	for _, child := range m.children {
+		if child.proc != nil && child.reaped && !child.restarting {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *muxer) childCount() string {
+	if m.policy.MaxChildren > 0 {
+		return fmt.Sprintf("%d of %d running", m.running(), m.policy.MaxChildren)
+	}
+	return fmt.Sprintf("%d running", m.running())
+}
+
+// makeRoom applies the AtCapacity policy when a child is needed for channelID
+// but MaxChildren are already running, and reports whether the child may be
+// started.
+func (m *muxer) makeRoom(channelID string, msg slackio.Message) bool {
+	switch m.policy.AtCapacity {
+	case "evict":
+		// The new child waits in the queue until a child exits, so that no more
+		// than MaxChildren ever run at once. A child is only evicted if the
+		// children already being stopped won't make room for every queued
+		// channel.
+		if !m.enqueue(channelID, msg) {
+			return false
+		}
+		if m.stopping() >= len(m.queue) {
+			fmt.Fprintf(os.Stderr, "slackbridge: at capacity (%s); %s will start once a stopping child process exits\n", m.childCount(), channelID)
+			return false
+		}
+
+		var lru *muxChild
+		for _, child := range m.children {
+			if child.proc != nil && !child.reaped && (lru == nil || child.active.Before(lru.active)) {
+				lru = child
+			}
+		}
+		if lru == nil {
+			return false
+		}
+		fmt.Fprintf(os.Stderr, "slackbridge: at capacity (%s); evicting child process %d for %s, idle for %v, to start %s\n",
+			m.childCount(), lru.proc.Pid(), lru.channelID, m.now().Sub(lru.active).Round(time.Second), channelID)
+
+		// Evicted children are restarted on their next message, as if reaped for
+		// being idle.
+		lru.reaped = true
+		go terminateChild(lru.proc)
+		return false
+
+	case "queue":
+		if !m.enqueue(channelID, msg) {
+			return false
+		}
+		fmt.Fprintf(os.Stderr, "slackbridge: at capacity (%s); queueing %s behind %d other channel(s)\n", m.childCount(), channelID, len(m.queue)-1)
+		m.notify(channelID, "_slackbridge: too many programs are running; this channel will be handled once another one exits_")
+		return false
+
+	default: // "refuse"
+		delete(m.buffers, channelID)
+		fmt.Fprintf(os.Stderr, "slackbridge: at capacity (%s); refusing message from %s\n", m.childCount(), channelID)
+		if !m.refused[channelID] {
+			m.refused[channelID] = true
+			m.notify(channelID, "_slackbridge: too many programs are running to handle this channel right now; try again later_")
+		}
+		return false
+	}
+}
+
+// enqueue adds a channel to the queue of those waiting for capacity, buffering
+// its messages in the meantime, and reports whether it was not already queued.
+func (m *muxer) enqueue(channelID string, msg slackio.Message) bool {
+	if _, ok := m.queued[channelID]; ok {
+		return false
+	}
+	m.queue = append(m.queue, channelID)
+	m.queued[channelID] = msg
+	if m.buffers[channelID] == nil {
+		m.startBuffer(msg)
+	}
+	return true
+}
+
+// dequeue starts children for queued channels while there is capacity.
+func (m *muxer) dequeue() {
+	for len(m.queue) > 0 && m.running()+len(m.admitted) < m.policy.MaxChildren {
+		channelID := m.queue[0]
+		msg := m.queued[channelID]
+		m.queue = m.queue[1:]
+		delete(m.queued, channelID)
+		m.admitted[channelID] = true
+		m.spawn(channelID, msg)
+	}
+}
+
+// report posts a notice in the given channel, if the policy calls for it.
+func (m *muxer) report(channelID, text string) {
+	if m.policy.ReportExits {
+		m.notify(channelID, text)
+	}
+}
+
+// notify posts a notice in the given channel. Notices are sent in another
+// goroutine, as the muxer's WriteClient may block while calling Slack.
+func (m *muxer) notify(channelID, text string) {
+	go m.writeClient.SendMessage(slackio.Message{ChannelID: channelID, Text: text})
+}
+
+// errMuxerStopped is returned by requests to a muxer that has stopped.
+var errMuxerStopped = errors.New("stopped")
+
+// do runs fn within the muxer's goroutine, and returns its result.
+func (m *muxer) do(fn func() error) error {
+	result := make(chan error, 1)
+	select {
+	case m.requests <- func() { result <- fn() }:
+		return <-result
+	case <-m.done:
+		return errMuxerStopped
+	}
+}
+
+// list returns a description of every channel's child process, including
+// those of channels waiting for capacity. It returns nil once the muxer has
+// stopped.
+func (m *muxer) list() []childInfo {
+	var infos []childInfo
+	m.do(func() error {
+		now := m.now()
+		infos = make([]childInfo, 0, len(m.children)+len(m.queue))
+		for _, child := range m.children {
+			info := childInfo{
+				ChannelID:    child.channelID,
+				PID:          child.pid,
+				State:        child.state(now),
+				Started:      child.started,
+				ExitCode:     child.exitCode,
+				Crashes:      child.crashes,
+				LastActivity: child.active,
+				Paused:       child.paused,
+			}
+			if child.proc != nil {
+				info.ExitCode = -1
+			}
+			if child.stream != nil {
+				var last time.Time
+				info.BytesIn, info.BytesOut, last = child.stream.stats()
+				if last.After(info.LastActivity) {
+					info.LastActivity = last
+				}
+			}
+			infos = append(infos, info)
+		}
+		for _, channelID := range m.queue {
+			infos = append(infos, childInfo{ChannelID: channelID, State: childQueued, ExitCode: -1})
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].ChannelID < infos[j].ChannelID })
+		return nil
+	})
+	return infos
+}
+
+// signalChild sends a signal to the running child process of a channel.
+func (m *muxer) signalChild(channelID string, sig os.Signal) error {
+	return m.do(func() error {
+		child, err := m.runningChild(channelID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "slackbridge: sending %v to child process %d for %s\n", sig, child.pid, channelID)
+		return child.proc.Signal(sig)
+	})
+}
+
+// restartChild stops the child process of a channel if it is running, and
+// starts it again with the same command line. Its input starts with the next
+// message from the channel. A channel that was given up on for crashing is
+// given a fresh start.
+func (m *muxer) restartChild(channelID string) error {
+	return m.do(func() error {
+		child := m.children[channelID]
+		if child == nil || child.pid == 0 {
+			return fmt.Errorf("no child process has been started for %s", channelID)
+		}
+
+		fmt.Fprintf(os.Stderr, "slackbridge: restarting the child process for %s\n", channelID)
+		child.crashes, child.gaveUp, child.retryAt = 0, false, time.Time{}
+		if child.proc == nil {
+			m.respawn(child)
+			return nil
+		}
+		if !child.restarting {
+			child.reaped, child.restarting = true, true
+			go terminateChild(child.proc)
+		}
+		return nil
+	})
+}
+
+// respawn starts a new child process for a channel, as if started by the same
+// message as the last one but receiving only new messages.
+func (m *muxer) respawn(child *muxChild) {
+	msg := child.first
+	msg.ID = -1
+	m.spawn(child.channelID, msg)
+}
+
+// pauseChild pauses or resumes the input of a channel's child process. The
+// setting also applies to any later child process of the channel.
+func (m *muxer) pauseChild(channelID string, paused bool) error {
+	return m.do(func() error {
+		child := m.children[channelID]
+		if child == nil {
+			return fmt.Errorf("no child process has been started for %s", channelID)
+		}
+
+		child.paused = paused
+		if child.stream != nil {
+			child.stream.setPaused(paused)
+		}
+		action := "resumed"
+		if paused {
+			action = "paused"
+		}
+		fmt.Fprintf(os.Stderr, "slackbridge: input %s for %s\n", action, channelID)
+		return nil
+	})
+}
+
+func (m *muxer) runningChild(channelID string) (*muxChild, error) {
+	child := m.children[channelID]
+	if child == nil || child.proc == nil {
+		return nil, fmt.Errorf("no child process is running for %s", channelID)
+	}
+	return child, nil
+}
+
+// stop stops spawning child processes, and terminates those already running.
+func (m *muxer) stop() {
+	m.stopOnce.Do(func() {
+		m.client.Unsubscribe(m.msgs)
+		close(m.msgs)
+		<-m.done
+
+		var wg sync.WaitGroup
+		for _, child := range m.children {
+			if child.proc == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(channelID string, proc *childproc.Process) {
+				defer wg.Done()
+				terminateChild(proc)
+				fmt.Fprintf(os.Stderr, "slackbridge: child process %d for %s stopped\n", proc.Pid(), channelID)
+			}(child.channelID, child.proc)
+		}
+		wg.Wait()
+	})
+}
+
+// terminateChild asks a child process to exit, kills it if it has not exited
+// within childStopTimeout, and waits for it to terminate.
+func terminateChild(child *childproc.Process) {
+	exited := make(chan struct{})
+	go func() {
+		child.Wait()
+		close(exited)
+	}()
+
+	child.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(childStopTimeout):
+		child.Signal(os.Kill)
+		<-exited
+	}
+}
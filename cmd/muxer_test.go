@@ -0,0 +1,523 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/childproc"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+func TestMuxPolicyBackoff(t *testing.T) {
+	testCases := []struct {
+		backoff, maxBackoff time.Duration
+		crashes             int
+		want                time.Duration
+	}{
+		{time.Second, 5 * time.Second, 0, 0},
+		{time.Second, 5 * time.Second, 1, time.Second},
+		{time.Second, 5 * time.Second, 2, 2 * time.Second},
+		{time.Second, 5 * time.Second, 3, 4 * time.Second},
+		{time.Second, 5 * time.Second, 4, 5 * time.Second},
+		{time.Second, 5 * time.Second, 100, 5 * time.Second},
+		{time.Second, 0, 4, 8 * time.Second},
+		{time.Second, 0, 1000, maxDuration},
+		{0, 5 * time.Second, 3, 0},
+	}
+
+	for _, tc := range testCases {
+		p := muxPolicy{Backoff: tc.backoff, MaxBackoff: tc.maxBackoff}
+		if got := p.backoff(tc.crashes); got != tc.want {
+			t.Errorf("backoff %v up to %v: backoff(%d) = %v; want %v", tc.backoff, tc.maxBackoff, tc.crashes, got, tc.want)
+		}
+	}
+}
+
+func TestMuxRespawn(t *testing.T) {
+	respawn := muxPolicy{Respawn: true, Backoff: time.Second, MaxBackoff: time.Minute, CrashWindow: 10 * time.Second}
+	onlyFailures := respawn
+	onlyFailures.RespawnOnlyFailures = true
+	crashLimit := respawn
+	crashLimit.CrashLimit = 1
+
+	const never = -1
+
+	// Each case starts a child, lets it run for runFor before it exits, and
+	// sends a second message. The child should be respawned after retryAfter,
+	// with the second message as its input.
+	testCases := []struct {
+		name       string
+		policy     muxPolicy
+		command    string
+		runFor     time.Duration
+		state      string
+		retryAfter time.Duration
+	}{
+		{"without respawn", muxPolicy{}, "exit 1", 0, childExited, never},
+		{"crash", respawn, "exit 1", 0, childBackoff, time.Second},
+		{"clean exit within the crash window", respawn, "exit 0", 0, childBackoff, time.Second},
+		{"exit after the crash window", respawn, "exit 1", 10 * time.Second, childExited, 0},
+		{"only failures, crash", onlyFailures, "exit 3", 0, childBackoff, time.Second},
+		{"only failures, clean exit", onlyFailures, "exit 0", 0, childExited, never},
+		{"only failures, clean exit after the crash window", onlyFailures, "exit 0", time.Minute, childExited, never},
+		{"crash limit", crashLimit, "exit 1", 0, childGaveUp, never},
+	}
+
+	for _, tc := range testCases {
+		mt := newMuxerTest(t, tc.policy, map[string]string{"C1": tc.command})
+
+		mt.send("C1", "first")
+		mt.resolve()
+		mt.now = mt.now.Add(tc.runFor)
+		mt.exit()
+		if state := mt.state("C1"); state != tc.state {
+			t.Errorf("%s: state after exit = %q; want %q", tc.name, state, tc.state)
+		}
+
+		mt.send("C1", "second")
+		switch tc.retryAfter {
+		case never:
+			mt.advance(time.Hour)
+			if mt.m.resolving["C1"] {
+				t.Errorf("%s: child was respawned", tc.name)
+			}
+		case 0:
+			mt.resolve()
+		default:
+			mt.advance(tc.retryAfter - time.Millisecond)
+			if mt.m.resolving["C1"] {
+				t.Errorf("%s: child was respawned before %v", tc.name, tc.retryAfter)
+			}
+			mt.advance(time.Millisecond)
+			mt.resolve()
+		}
+
+		want := []muxStart{{"C1", []string{"first"}}}
+		if tc.retryAfter != never {
+			want = append(want, muxStart{"C1", []string{"second"}})
+		}
+		if !reflect.DeepEqual(mt.started, want) {
+			t.Errorf("%s: started %v; want %v", tc.name, mt.started, want)
+		}
+		mt.close()
+	}
+}
+
+func TestMuxCrashLimit(t *testing.T) {
+	testCases := []struct {
+		limit   int
+		crashes int
+		delays  []time.Duration // before each respawn
+		gaveUp  bool
+	}{
+		{0, 5, []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, 4 * time.Second}, false},
+		{3, 2, []time.Duration{1 * time.Second, 2 * time.Second}, false},
+		{3, 3, []time.Duration{1 * time.Second, 2 * time.Second}, true},
+		{1, 1, nil, true},
+	}
+
+	for _, tc := range testCases {
+		policy := muxPolicy{Respawn: true, Backoff: time.Second, MaxBackoff: 4 * time.Second, CrashWindow: 10 * time.Second, CrashLimit: tc.limit}
+		mt := newMuxerTest(t, policy, map[string]string{"C1": "exit 1"})
+
+		var delays []time.Duration
+		mt.send("C1", "hello")
+		for i := 0; i < tc.crashes; i++ {
+			mt.resolve()
+			mt.exit()
+
+			child := mt.m.children["C1"]
+			if child.gaveUp {
+				break
+			}
+			delay := child.retryAt.Sub(mt.now)
+			delays = append(delays, delay)
+			if i < tc.crashes-1 {
+				mt.send("C1", "hello")
+				mt.advance(delay)
+			}
+		}
+
+		child := mt.m.children["C1"]
+		if !reflect.DeepEqual(delays, tc.delays) || child.gaveUp != tc.gaveUp || child.crashes != tc.crashes {
+			t.Errorf("limit %d: after %d crashes, delays = %v, gave up = %v, crashes = %d; want %v, %v, %d",
+				tc.limit, tc.crashes, delays, child.gaveUp, child.crashes, tc.delays, tc.gaveUp, tc.crashes)
+		}
+
+		if tc.gaveUp {
+			mt.waitForNotice("keeps crashing")
+		} else if notices := mt.notices(); len(notices) > 0 {
+			t.Errorf("limit %d: sent notices %q; want none", tc.limit, notices)
+		}
+		mt.close()
+	}
+}
+
+func TestMuxIdleReaping(t *testing.T) {
+	testCases := []struct {
+		name        string
+		idleTimeout time.Duration
+		activity    []time.Duration // when messages arrive, after the first
+		tickAt      time.Duration
+		reaped      bool
+	}{
+		{"idle", time.Minute, nil, time.Minute, true},
+		{"not yet idle", time.Minute, nil, 59 * time.Second, false},
+		{"activity", time.Minute, []time.Duration{30 * time.Second}, time.Minute, false},
+		{"idle after activity", time.Minute, []time.Duration{30 * time.Second}, 90 * time.Second, true},
+		{"no idle timeout", 0, nil, time.Hour, false},
+	}
+
+	for _, tc := range testCases {
+		mt := newMuxerTest(t, muxPolicy{IdleTimeout: tc.idleTimeout}, map[string]string{"C1": "exec sleep 60"})
+
+		start := mt.now
+		mt.send("C1", "first")
+		mt.resolve()
+		for _, d := range tc.activity {
+			mt.now = start.Add(d)
+			mt.send("C1", "more")
+		}
+		mt.advance(start.Add(tc.tickAt).Sub(mt.now))
+
+		want := childRunning
+		if tc.reaped {
+			want = childStopping
+		}
+		if state := mt.state("C1"); state != want {
+			t.Errorf("%s: state = %q; want %q", tc.name, state, want)
+			mt.close()
+			continue
+		}
+
+		if tc.reaped {
+			// Reaped children start again on the next message, without respawn.
+			mt.exit()
+			if state := mt.state("C1"); state != childStopped {
+				t.Errorf("%s: state after exit = %q; want %q", tc.name, state, childStopped)
+			}
+			mt.send("C1", "again")
+			mt.resolve()
+			if n := len(mt.started); n != 2 {
+				t.Errorf("%s: started %d children; want 2", tc.name, n)
+			}
+		}
+		mt.close()
+	}
+}
+
+func TestMuxAtCapacity(t *testing.T) {
+	// Each case runs a child for C1 at a limit of one child, then sends two
+	// messages from C2.
+	testCases := []struct {
+		atCapacity string
+		state      string // of C1's child, after the messages from C2
+		queued     bool   // whether C2 waits for capacity
+		notice     string
+	}{
+		{"evict", childStopping, true, ""},
+		{"queue", childRunning, true, "will be handled once another one exits"},
+		{"refuse", childRunning, false, "try again later"},
+	}
+
+	for _, tc := range testCases {
+		policy := muxPolicy{MaxChildren: 1, AtCapacity: tc.atCapacity}
+		mt := newMuxerTest(t, policy, map[string]string{"C1": "exec sleep 60", "C2": "exec sleep 60"})
+
+		mt.send("C1", "c1")
+		mt.resolve()
+		mt.send("C2", "c2 first")
+		mt.resolve()
+		mt.send("C2", "c2 second")
+		if !tc.queued {
+			// Refused channels are reconsidered on each message.
+			mt.resolve()
+		}
+
+		if state := mt.state("C1"); state != tc.state {
+			t.Errorf("%s: C1 state = %q; want %q", tc.atCapacity, state, tc.state)
+		}
+		if queued := reflect.DeepEqual(mt.m.queue, []string{"C2"}); queued != tc.queued {
+			t.Errorf("%s: queue = %q; want C2 queued: %v", tc.atCapacity, mt.m.queue, tc.queued)
+		}
+		if tc.notice != "" {
+			mt.waitForNotice(tc.notice)
+			if notices := mt.notices(); len(notices) != 1 {
+				t.Errorf("%s: sent notices %q; want 1", tc.atCapacity, notices)
+			}
+		}
+
+		want := []muxStart{{"C1", []string{"c1"}}}
+		if tc.queued {
+			// C2 starts with its buffered messages once C1's child exits.
+			if tc.state == childRunning {
+				go terminateChild(mt.m.children["C1"].proc)
+			}
+			mt.exit()
+			mt.resolve()
+			want = append(want, muxStart{"C2", []string{"c2 first", "c2 second"}})
+		}
+		if !reflect.DeepEqual(mt.started, want) {
+			t.Errorf("%s: started %v; want %v", tc.atCapacity, mt.started, want)
+		}
+		if n := mt.m.running(); n != 1 {
+			t.Errorf("%s: %d children running; want 1", tc.atCapacity, n)
+		}
+		mt.close()
+	}
+}
+
+func TestMuxRestartAtCapacity(t *testing.T) {
+	policy := muxPolicy{MaxChildren: 1, AtCapacity: "queue"}
+	mt := newMuxerTest(t, policy, map[string]string{"C1": "exec sleep 60", "C2": "exec sleep 60"})
+	defer mt.close()
+
+	mt.send("C1", "c1")
+	mt.resolve()
+	mt.send("C2", "c2")
+	mt.resolve()
+
+	if err := mt.restart("C1"); err != nil {
+		t.Fatal(err)
+	}
+	mt.exit()
+
+	// The restarted child keeps its capacity, rather than giving it up to C2.
+	if want := []string{"C2"}; !reflect.DeepEqual(mt.m.queue, want) {
+		t.Errorf("queue after restarting C1 = %q; want %q", mt.m.queue, want)
+	}
+	mt.resolve()
+
+	want := []muxStart{{"C1", []string{"c1"}}, {"C1", nil}}
+	if !reflect.DeepEqual(mt.started, want) {
+		t.Errorf("started %v; want %v", mt.started, want)
+	}
+	if state := mt.state("C1"); state != childRunning {
+		t.Errorf("C1 state = %q; want %q", state, childRunning)
+	}
+	if len(mt.m.admitted) > 0 {
+		t.Errorf("admitted = %v after the restart; want none", mt.m.admitted)
+	}
+}
+
+func TestMuxStartupBuffer(t *testing.T) {
+	// Each case sets --startup-buffer, and sends messages from a channel before
+	// its route is resolved.
+	testCases := []struct {
+		limit  int
+		texts  []string
+		input  []string
+		notice bool
+	}{
+		{0, []string{"a", "b", "c", "d", "e"}, []string{"a", "b", "c", "d", "e"}, false},
+		{3, []string{"a", "b"}, []string{"a", "b"}, false},
+		{3, []string{"a", "b", "c"}, []string{"a", "b", "c"}, false},
+		{3, []string{"a", "b", "c", "d", "e"}, []string{"a", "b", "c"}, true},
+		{1, []string{"a", "b"}, []string{"a"}, true},
+	}
+
+	for _, tc := range testCases {
+		cmd := &cobra.Command{Use: "test"}
+		addMuxPolicyFlags(cmd)
+		if err := cmd.ParseFlags([]string{"--startup-buffer", strconv.Itoa(tc.limit)}); err != nil {
+			t.Fatal(err)
+		}
+		policy, err := muxPolicyFromFlags(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt := newMuxerTest(t, policy, map[string]string{"C1": "exec sleep 60"})
+
+		for _, text := range tc.texts {
+			mt.send("C1", text)
+		}
+		mt.resolve()
+
+		want := []muxStart{{"C1", tc.input}}
+		if !reflect.DeepEqual(mt.started, want) {
+			t.Errorf("limit %d: started %v; want %v", tc.limit, mt.started, want)
+		}
+
+		// Messages after the child starts are left to its Reader.
+		mt.send("C1", "live")
+		if _, ok := mt.m.buffers["C1"]; ok {
+			t.Errorf("limit %d: message buffered after the child started", tc.limit)
+		}
+
+		if tc.notice {
+			mt.waitForNotice("some messages were dropped")
+			if notices := mt.notices(); len(notices) != 1 {
+				t.Errorf("limit %d: sent notices %q; want 1", tc.limit, notices)
+			}
+		} else if notices := mt.notices(); len(notices) > 0 {
+			t.Errorf("limit %d: sent notices %q; want none", tc.limit, notices)
+		}
+		mt.close()
+	}
+}
+
+// muxerTest drives a muxer from the test's goroutine, in place of the muxer's
+// own goroutine, with a fake clock and router. Children run real shell
+// commands, without input or output.
+type muxerTest struct {
+	t      *testing.T
+	m      *muxer
+	now    time.Time
+	nextID int
+
+	started []muxStart
+
+	mu   sync.Mutex
+	sent []slackio.Message
+}
+
+// muxStart records the start of a child process.
+type muxStart struct {
+	channelID string
+	input     []string // the text of the buffered messages
+}
+
+// newMuxerTest returns a muxerTest whose muxer runs the given shell command for
+// each channel, and ignores other channels.
+func newMuxerTest(t *testing.T, policy muxPolicy, commands map[string]string) *muxerTest {
+	mt := &muxerTest{t: t, now: time.Unix(1500000000, 0)}
+	mt.m = newMuxer(nil, nil, mt, nil, slackio.ReaderOptions{}, nil, muxChildSpec{}, policy)
+	mt.m.now = func() time.Time { return mt.now }
+	mt.m.resolve = func(msg slackio.Message) muxResolution {
+		res := muxResolution{msg: msg}
+		if command, ok := commands[msg.ChannelID]; ok {
+			res.command = []string{"sh", "-c", command}
+		}
+		return res
+	}
+	mt.m.startChild = func(channelID string, args []string, opts childproc.Options, buffered []slackio.Message, _ *childStream) (*childproc.Process, error) {
+		start := muxStart{channelID: channelID}
+		for _, msg := range buffered {
+			start.input = append(start.input, msg.Text)
+		}
+		mt.started = append(mt.started, start)
+		return childproc.SpawnWithOptions(args, ioutil.NopCloser(strings.NewReader("")), discardCloser{}, opts)
+	}
+	return mt
+}
+
+// SendMessage implements slackio.WriteClient, recording the muxer's notices.
+func (mt *muxerTest) SendMessage(m slackio.Message) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.sent = append(mt.sent, m)
+}
+
+// notices returns the text of the notices that the muxer has sent so far.
+func (mt *muxerTest) notices() []string {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	var notices []string
+	for _, m := range mt.sent {
+		notices = append(notices, m.Text)
+	}
+	return notices
+}
+
+// waitForNotice waits for the muxer to send a notice containing text.
+func (mt *muxerTest) waitForNotice(text string) {
+	mt.t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		for _, notice := range mt.notices() {
+			if strings.Contains(notice, text) {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			mt.t.Fatalf("timed out waiting for a notice containing %q; sent %q", text, mt.notices())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// send delivers a message from the given channel to the muxer.
+func (mt *muxerTest) send(channelID, text string) {
+	mt.nextID++
+	mt.m.receive(slackio.Message{ID: mt.nextID, ChannelID: channelID, Text: text})
+}
+
+// resolve waits for the muxer to resolve the route of a channel, and lets it
+// start the channel's child.
+func (mt *muxerTest) resolve() {
+	mt.t.Helper()
+	select {
+	case res := <-mt.m.resolved:
+		mt.m.spawnResolved(res)
+	case <-time.After(testTimeout):
+		mt.t.Fatal("timed out waiting for a route to be resolved")
+	}
+}
+
+// exit waits for a child process to exit, and lets the muxer handle it.
+func (mt *muxerTest) exit() {
+	mt.t.Helper()
+	select {
+	case exit := <-mt.m.exits:
+		mt.m.exited(exit)
+	case <-time.After(testTimeout):
+		mt.t.Fatal("timed out waiting for a child process to exit")
+	}
+}
+
+// restart asks the muxer to restart a channel's child, as the admin API does.
+func (mt *muxerTest) restart(channelID string) error {
+	mt.t.Helper()
+
+	result := make(chan error, 1)
+	go func() { result <- mt.m.restartChild(channelID) }()
+	select {
+	case req := <-mt.m.requests:
+		req()
+	case <-time.After(testTimeout):
+		mt.t.Fatal("timed out waiting for the restart request")
+	}
+	return <-result
+}
+
+// advance moves the clock forward, and lets the muxer act on the time.
+func (mt *muxerTest) advance(d time.Duration) {
+	mt.now = mt.now.Add(d)
+	mt.m.tick(mt.now)
+}
+
+// state returns the state of a channel's child, or "" if it has none.
+func (mt *muxerTest) state(channelID string) string {
+	child := mt.m.children[channelID]
+	if child == nil {
+		return ""
+	}
+	return child.state(mt.now)
+}
+
+// close stops any running children, and releases the muxer's goroutines.
+func (mt *muxerTest) close() {
+	close(mt.m.done)
+	for _, child := range mt.m.children {
+		if child.proc != nil {
+			terminateChild(child.proc)
+		}
+	}
+}
+
+type discardCloser struct{}
+
+func (discardCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (discardCloser) Close() error                { return nil }
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"sync"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// muxBuffer holds the messages received from a channel while its child
+// process is waiting to start, up to a limit.
+type muxBuffer struct {
+	msgs    []slackio.Message
+	limit   int // 0 for no limit
+	dropped int
+}
+
+// add buffers a message, and reports whether it fit.
+func (b *muxBuffer) add(msg slackio.Message) bool {
+	if b.limit > 0 && len(b.msgs) >= b.limit {
+		b.dropped++
+		return false
+	}
+	b.msgs = append(b.msgs, msg)
+	return true
+}
+
+// muxFeed is the ReadClient for the Reader of a mux child process. It delivers
+// the messages that the muxer buffered for the channel before the child
+// started, followed by those received from its underlying client afterward.
+// This avoids relying on the client's limited history to replay messages that
+// arrived while the child was waiting to start.
+type muxFeed struct {
+	client   slackio.ReadClient // subscribed just after the buffered messages
+	buffered []slackio.Message
+
+	mu   sync.Mutex
+	subs map[chan<- slackio.Message]*muxFeedSub
+}
+
+type muxFeedSub struct {
+	live chan slackio.Message
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newMuxFeed(client slackio.ReadClient, buffered []slackio.Message) *muxFeed {
+	return &muxFeed{
+		client:   client,
+		buffered: buffered,
+		subs:     make(map[chan<- slackio.Message]*muxFeedSub),
+	}
+}
+
+// Subscribe implements slackio.ReadClient.
+func (f *muxFeed) Subscribe(ch chan<- slackio.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subs[ch]; ok {
+		return slackio.ErrAlreadySubscribed
+	}
+
+	sub := &muxFeedSub{
+		live: make(chan slackio.Message),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := f.client.Subscribe(sub.live); err != nil {
+		return err
+	}
+	f.subs[ch] = sub
+
+	// Each subscriber receives the buffered messages only once.
+	buffered := f.buffered
+	f.buffered = nil
+
+	go func() {
+		defer close(sub.done)
+
+		for _, msg := range buffered {
+			select {
+			case ch <- msg:
+			case <-sub.stop:
+				return
+			}
+		}
+
+		for {
+			select {
+			case msg := <-sub.live:
+				select {
+				case ch <- msg:
+				case <-sub.stop:
+					return
+				}
+			case <-sub.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Unsubscribe implements slackio.ReadClient.
+func (f *muxFeed) Unsubscribe(ch chan<- slackio.Message) error {
+	f.mu.Lock()
+	sub, ok := f.subs[ch]
+	delete(f.subs, ch)
+	f.mu.Unlock()
+
+	if !ok {
+		return slackio.ErrNotSubscribed
+	}
+
+	err := f.client.Unsubscribe(sub.live)
+	close(sub.stop)
+	<-sub.done
+	return err
+}
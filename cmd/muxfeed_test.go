@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+func TestMuxBuffer(t *testing.T) {
+	testCases := []struct {
+		limit, n      int
+		kept, dropped int
+	}{
+		{0, 5, 5, 0},
+		{3, 2, 2, 0},
+		{3, 3, 3, 0},
+		{3, 5, 3, 2},
+		{1, 4, 1, 3},
+	}
+
+	for _, tc := range testCases {
+		buf := &muxBuffer{limit: tc.limit}
+		for i := 1; i <= tc.n; i++ {
+			if fit := buf.add(slackio.Message{ID: i}); fit != (i <= tc.kept) {
+				t.Errorf("limit %d: add(%d) = %v; want %v", tc.limit, i, fit, i <= tc.kept)
+			}
+		}
+		if len(buf.msgs) != tc.kept || buf.dropped != tc.dropped {
+			t.Errorf("limit %d: after %d messages, kept %d and dropped %d; want %d and %d",
+				tc.limit, tc.n, len(buf.msgs), buf.dropped, tc.kept, tc.dropped)
+		}
+		for i, msg := range buf.msgs {
+			if msg.ID != i+1 {
+				t.Errorf("limit %d: message %d has ID %d; want %d", tc.limit, i, msg.ID, i+1)
+			}
+		}
+	}
+}
+
+func TestMuxFeedOrder(t *testing.T) {
+	client := newFakeReadClient()
+	feed := newMuxFeed(client, textMessages("one", "two", "three"))
+
+	ch := make(chan slackio.Message)
+	if err := feed.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Unsubscribe(ch)
+
+	// Messages received live, even before the buffered messages are read, are
+	// delivered after them.
+	go client.deliver(textMessages("four", "five")...)
+
+	var got []string
+	for len(got) < 5 {
+		select {
+		case msg := <-ch:
+			got = append(got, msg.Text)
+		case <-time.After(time.Second):
+			t.Fatalf("received %q; want 5 messages", got)
+		}
+	}
+	if want := []string{"one", "two", "three", "four", "five"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %q; want %q", got, want)
+	}
+}
+
+func TestMuxFeedSubscriptions(t *testing.T) {
+	client := newFakeReadClient()
+	feed := newMuxFeed(client, textMessages("buffered"))
+
+	ch := make(chan slackio.Message)
+	if err := feed.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := feed.Subscribe(ch); err != slackio.ErrAlreadySubscribed {
+		t.Errorf("second Subscribe() = %v; want %v", err, slackio.ErrAlreadySubscribed)
+	}
+	if n := client.subscribers(); n != 1 {
+		t.Errorf("client has %d subscribers; want 1", n)
+	}
+
+	// Unsubscribing doesn't wait for the buffered message to be read.
+	if err := feed.Unsubscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := feed.Unsubscribe(ch); err != slackio.ErrNotSubscribed {
+		t.Errorf("second Unsubscribe() = %v; want %v", err, slackio.ErrNotSubscribed)
+	}
+	if n := client.subscribers(); n != 0 {
+		t.Errorf("client has %d subscribers after Unsubscribe; want 0", n)
+	}
+
+	// Buffered messages are only delivered to the first subscription.
+	if err := feed.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Unsubscribe(ch)
+	go client.deliver(textMessages("live")...)
+	select {
+	case msg := <-ch:
+		if msg.Text != "live" {
+			t.Errorf("resubscribed and received %q; want %q", msg.Text, "live")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+}
+
+// fakeReadClient is a slackio.ReadClient whose messages are delivered by
+// the test.
+type fakeReadClient struct {
+	mu   sync.Mutex
+	subs map[chan<- slackio.Message]bool
+}
+
+func newFakeReadClient() *fakeReadClient {
+	return &fakeReadClient{subs: make(map[chan<- slackio.Message]bool)}
+}
+
+func (c *fakeReadClient) Subscribe(ch chan<- slackio.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subs[ch] = true
+	return nil
+}
+
+func (c *fakeReadClient) Unsubscribe(ch chan<- slackio.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, ch)
+	return nil
+}
+
+func (c *fakeReadClient) subscribers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.subs)
+}
+
+// deliver sends messages to every subscriber, blocking until each accepts
+// them.
+func (c *fakeReadClient) deliver(msgs ...slackio.Message) {
+	c.mu.Lock()
+	subs := make([]chan<- slackio.Message, 0, len(c.subs))
+	for ch := range c.subs {
+		subs = append(subs, ch)
+	}
+	c.mu.Unlock()
+
+	for _, msg := range msgs {
+		for _, ch := range subs {
+			ch <- msg
+		}
+	}
+}
+
+// textMessages returns messages from C1 with the given text and increasing
+// IDs.
+func textMessages(texts ...string) []slackio.Message {
+	msgs := make([]slackio.Message, len(texts))
+	for i, text := range texts {
+		msgs[i] = slackio.Message{ID: i + 1, ChannelID: "C1", Text: text}
+	}
+	return msgs
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+	"go.alexhamlin.co/slackbridge/internal/webclient"
+)
+
+// addOutputFlags adds flags to the given command that control how messages are
+// sent to Slack. Commands using these flags should construct their
+// slackio.WriteClient with newWriteClient.
+func addOutputFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("send-via", "rtm", `API used to send messages: "rtm" (same connection as received messages) or "web" (chat.postMessage)`)
+	addWebOptionFlags(cmd, " (requires --send-via=web)")
+}
+
+// addWebOptionFlags adds flags to the given command that customize the
+// presentation of messages sent through the Web API, with note appended to the
+// description of each. See webOptions.
+func addWebOptionFlags(cmd *cobra.Command, note string) {
+	flags := cmd.Flags()
+	flags.String("username", "", "name to display with sent messages"+note)
+	flags.String("icon-emoji", "", "emoji to display as the icon of sent messages"+note)
+	flags.String("icon-url", "", "image URL to display as the icon of sent messages"+note)
+	flags.Bool("no-unfurl", false, "disable unfurling of links and media in sent messages"+note)
+}
+
+// webOptions returns the webclient.Options selected by the flags added through
+// addWebOptionFlags.
+func webOptions(cmd *cobra.Command) webclient.Options {
+	flags := cmd.Flags()
+
+	var opts webclient.Options
+	opts.Username, _ = flags.GetString("username")
+	opts.IconEmoji, _ = flags.GetString("icon-emoji")
+	opts.IconURL, _ = flags.GetString("icon-url")
+	opts.NoUnfurl, _ = flags.GetBool("no-unfurl")
+	return opts
+}
+
+// newWriteClient returns the slackio.WriteClient selected by the flags added
+// through addOutputFlags. If messages are to be sent using the same connection
+// through which they are received, the provided Client is returned unmodified.
+func newWriteClient(cmd *cobra.Command, apiToken string, client *slackio.Client) (slackio.WriteClient, error) {
+	flags := cmd.Flags()
+	sendVia, _ := flags.GetString("send-via")
+
+	opts := webOptions(cmd)
+
+	switch sendVia {
+	case "rtm":
+		if opts != (webclient.Options{}) {
+			return nil, fmt.Errorf("--username, --icon-emoji, --icon-url, and --no-unfurl require --send-via=web")
+		}
+		return client, nil
+
+	case "web":
+		wc := webclient.New(apiToken, opts)
+		wc.ErrorHandler = func(err error) {
+			fmt.Fprintf(os.Stderr, "slackbridge: failed to send message: %v\n", err)
+		}
+		// Prevent Readers from receiving our own messages, as they would if we
+		// had sent them through the Client.
+		wc.PostHandler = func(m slackio.Message) {
+			client.IgnoreTimestamp(m.Timestamp)
+		}
+		return wc, nil
+
+	default:
+		return nil, fmt.Errorf("unknown --send-via value %q", sendVia)
+	}
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// relayAttribution is the template used for received text with --attribute.
+const relayAttribution = "*{{.User}}:* {{.Text}}"
+
+var relayCmd = &cobra.Command{
+	Use:     "relay --from CHANNEL --to CHANNEL",
+	Example: "relay --from C12345678 --to C87654321 --bidirectional --attribute",
+	Short:   "Forward messages from one Slack channel to another",
+	Long: `Relay forwards text from the main body of one channel (i.e. excluding
+threads, unless --include-threads is given) to another, as if the output of
+the stream command were piped into the exec command. With --bidirectional,
+text is forwarded in both directions.
+
+By default, both channels are accessed with SLACK_TOKEN. To relay between two
+workspaces, set SLACK_TO_TOKEN to a token for the workspace containing the --to
+channel (and SLACK_TO_APP_TOKEN to its app-level token, with
+--receive-via=socket). The Events API is not supported across workspaces.
+
+To prevent loops, messages sent by the user associated with either token are
+never relayed. This includes messages sent by other slackbridge processes using
+the same tokens.
+
+With --attribute, each line of relayed text is prefixed with the name of its
+author. For other formats, use --template.`,
+
+	Args: cobra.NoArgs,
+	Run:  runRelayCmd,
+}
+
+func init() {
+	RootCmd.AddCommand(relayCmd)
+	flags := relayCmd.Flags()
+	flags.String("from", "", "ID of the channel to forward messages from (required)")
+	relayCmd.MarkFlagRequired("from")
+	flags.String("to", "", "ID of the channel to forward messages to (required)")
+	relayCmd.MarkFlagRequired("to")
+	flags.Bool("bidirectional", false, "also forward messages from --to to --from")
+	flags.Bool("attribute", false, "prefix each line of relayed text with the name of its author (shorthand for --template='"+relayAttribution+"')")
+	addInputFlags(relayCmd)
+	addOutputFlags(relayCmd)
+}
+
+// relayEnd is one of the channels connected by a relay.
+type relayEnd struct {
+	apiToken    string
+	channelID   string
+	client      *slackio.Client
+	writeClient slackio.WriteClient
+	self        slackio.Identity
+}
+
+func runRelayCmd(cmd *cobra.Command, args []string) {
+	apiToken := os.Getenv("SLACK_TOKEN")
+	if apiToken == "" {
+		fmt.Fprintln(os.Stderr, "Error: SLACK_TOKEN environment variable not set")
+		fmt.Fprintln(os.Stderr, RootCmd.UsageString())
+		os.Exit(1)
+	}
+
+	flags := cmd.Flags()
+	fromChannel, _ := flags.GetString("from")
+	toChannel, _ := flags.GetString("to")
+	bidirectional, _ := flags.GetBool("bidirectional")
+	receiveVia, _ := flags.GetString("receive-via")
+
+	toToken := os.Getenv("SLACK_TO_TOKEN")
+	if toToken == "" {
+		toToken = apiToken
+	}
+	if toToken == apiToken && fromChannel == toChannel {
+		exitWithError(errors.New("--from and --to must be different channels"))
+	}
+	if toToken != apiToken && receiveVia == "events" {
+		exitWithError(errors.New("--receive-via=events does not support relaying between workspaces"))
+	}
+
+	if attribute, _ := flags.GetBool("attribute"); attribute {
+		if flags.Changed("template") {
+			exitWithError(errors.New("--attribute and --template cannot be used together"))
+		}
+		flags.Set("template", relayAttribution)
+	}
+
+	from, err := newRelayEnd(cmd, apiToken, "SLACK_APP_TOKEN", fromChannel, nil)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	// Within a single workspace, both ends share a connection.
+	var shared *relayEnd
+	if toToken == apiToken {
+		shared = &from
+	}
+	to, err := newRelayEnd(cmd, toToken, "SLACK_TO_APP_TOKEN", toChannel, shared)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	errCh := make(chan error, 2)
+	if err := startRelay(cmd, from, to, errCh); err != nil {
+		exitWithError(err)
+	}
+	if bidirectional {
+		if err := startRelay(cmd, to, from, errCh); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	// Relaying stops when either end's Client fails, as well as when a Reader
+	// stops.
+	select {
+	case err = <-errCh:
+	case <-from.client.Failed():
+		err = from.client.Err()
+	case <-to.client.Failed():
+		err = to.client.Err()
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// newRelayEnd connects to the workspace of the given channel. If shared is
+// non-nil, its connection is reused rather than creating a new one.
+func newRelayEnd(cmd *cobra.Command, apiToken, appTokenVar, channelID string, shared *relayEnd) (relayEnd, error) {
+	end := relayEnd{apiToken: apiToken, channelID: channelID}
+	if shared != nil {
+		end.client, end.writeClient, end.self = shared.client, shared.writeClient, shared.self
+		return end, nil
+	}
+
+	var err error
+	if end.client, err = newClientWithAppToken(cmd, apiToken, appTokenVar); err != nil {
+		return end, err
+	}
+	if end.writeClient, err = newWriteClient(cmd, apiToken, end.client); err != nil {
+		return end, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clientSetupTimeout)
+	defer cancel()
+	end.self, err = slackio.AuthTest(ctx, apiToken)
+	return end, err
+}
+
+// startRelay starts forwarding text from src to dst, and sends the result to
+// errCh if forwarding stops.
+func startRelay(cmd *cobra.Command, src, dst relayEnd, errCh chan<- error) error {
+	opts, err := readerOptions(cmd, src.apiToken, false)
+	if err != nil {
+		return err
+	}
+
+	opts.Filter = relayFilter(src.self, dst.self, opts.Filter)
+
+	reader := slackio.NewReaderWithOptions(newSubscriber(cmd, src.client, -1, src.channelID, src.writeClient), src.channelID, opts)
+	writer := slackio.NewWriter(dst.writeClient, dst.channelID, nil)
+
+	go func() {
+		_, err := io.Copy(writer, reader)
+		writer.Close()
+		errCh <- err
+	}()
+	return nil
+}
+
+// relayFilter wraps filter (which may be nil) to reject messages sent by
+// either identity. Messages in src from either identity were either relayed
+// from dst, or sent by something else relaying on our behalf.
+func relayFilter(src, dst slackio.Identity, filter func(slackio.Message) bool) func(slackio.Message) bool {
+	return func(m slackio.Message) bool {
+		if m.UserID != "" && (m.UserID == src.UserID || m.UserID == src.BotID ||
+			m.UserID == dst.UserID || m.UserID == dst.BotID) {
+			return false
+		}
+		return filter == nil || filter(m)
+	}
+}
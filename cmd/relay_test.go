@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+func TestRelayFilter(t *testing.T) {
+	src := slackio.Identity{UserID: "USRC00000", BotID: "BSRC00000"}
+	dst := slackio.Identity{UserID: "UDST00000", BotID: "BDST00000"}
+	noBot := slackio.Identity{UserID: "UDST00000"}
+	notSecret := func(m slackio.Message) bool { return !strings.Contains(m.Text, "secret") }
+
+	testCases := []struct {
+		name     string
+		src, dst slackio.Identity
+		filter   func(slackio.Message) bool
+		userID   string
+		text     string
+		want     bool
+	}{
+		{"other user", src, dst, nil, "UOTHER000", "hello", true},
+		{"source user", src, dst, nil, "USRC00000", "hello", false},
+		{"source bot", src, dst, nil, "BSRC00000", "hello", false},
+		{"destination user", src, dst, nil, "UDST00000", "hello", false},
+		{"destination bot", src, dst, nil, "BDST00000", "hello", false},
+		{"unknown sender", src, dst, nil, "", "hello", true},
+		{"unknown sender without a bot", src, noBot, nil, "", "hello", true},
+		{"other user passing the filter", src, dst, notSecret, "UOTHER000", "hello", true},
+		{"other user failing the filter", src, dst, notSecret, "UOTHER000", "secret", false},
+		{"own bot passing the filter", src, dst, notSecret, "BDST00000", "hello", false},
+	}
+
+	for _, tc := range testCases {
+		filter := relayFilter(tc.src, tc.dst, tc.filter)
+		m := slackio.Message{ChannelID: "C1", UserID: tc.userID, Text: tc.text}
+		if got := filter(m); got != tc.want {
+			t.Errorf("%s: filter(%+v) = %v; want %v", tc.name, m, got, tc.want)
+		}
+	}
+}
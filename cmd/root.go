@@ -1,7 +1,22 @@
 // Package cmd implements the functionality of command slackbridge.
 package cmd // import "go.alexhamlin.co/slackbridge/cmd"
 
-import "github.com/spf13/cobra"
+import (
+	"fmt"
+	"os"
+
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// Exit codes used by slackbridge commands. Cobra itself exits with 1 for usage
+// errors.
+const (
+	exitError       = 1 // any other error
+	exitInvalidAuth = 3 // Slack rejected the provided API credentials
+)
 
 // Version is the current version of slackbridge, which may be injected at
 // build time. If it is injected, slackbridge will support an additional
@@ -14,3 +29,26 @@ var RootCmd = &cobra.Command{
 	Short:   "slackbridge connects your command line to Slack",
 	Version: Version,
 }
+
+func init() {
+	// SLACK_API_URL is undocumented in the CLI itself, as it's only useful for
+	// pointing slackbridge at a local stand-in for Slack (see package
+	// slacktest) during testing.
+	if apiURL := os.Getenv("SLACK_API_URL"); apiURL != "" {
+		slack.APIURL = apiURL
+	}
+}
+
+// exitWithError prints err and terminates slackbridge. Errors indicating that
+// Slack rejected the API credentials are explained in more detail, and result
+// in the distinct exitInvalidAuth status so that scripts and supervisors can
+// tell them apart from transient failures.
+func exitWithError(err error) {
+	if slackio.IsAuthError(err) {
+		fmt.Fprintln(os.Stderr, "Error: Slack rejected the API credentials; check that SLACK_TOKEN (and SLACK_APP_TOKEN or SLACK_TO_TOKEN, if used) is valid and has not been revoked")
+		os.Exit(exitInvalidAuth)
+	}
+
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(exitError)
+}
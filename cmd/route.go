@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/directory"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// channelIDPattern matches strings that look like Slack channel IDs, which may
+// be used as route patterns without a "id:" prefix.
+var channelIDPattern = regexp.MustCompile(`^[CDG][A-Z0-9]{8,}$`)
+
+// muxRoute maps the channels that match a pattern to a command line.
+type muxRoute struct {
+	spec    string // the route as written, for error messages
+	kind    string // "id", "name", "type", "text", or "any"
+	value   string
+	re      *regexp.Regexp // for "text" routes
+	command []string       // nil to ignore matching channels
+}
+
+// muxRouter selects the command line to run for each channel in mux mode.
+// Channels rejected by the filter are ignored outright. Otherwise, routes are
+// evaluated in order, and the first matching route wins. Channels matching no
+// route use the default command, or are ignored if there is none.
+type muxRouter struct {
+	dir            *directory.Directory
+	filter         *messageFilter // may be nil
+	routes         []muxRoute
+	defaultCommand []string
+}
+
+// addRouteFlags adds flags to the given command that configure a muxRouter.
+func addRouteFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringArray("route", nil, "run COMMAND for channels matching PATTERN, given as PATTERN=COMMAND (repeatable, first match wins)")
+	flags.String("routes-file", "", "file of PATTERN=COMMAND routes, one per line, evaluated after those given with --route")
+}
+
+// newMuxRouter returns the muxRouter selected by the flags added through
+// addRouteFlags and addMuxFilterFlags, with the given default command (which
+// may be empty).
+func newMuxRouter(cmd *cobra.Command, dir *directory.Directory, defaultCommand []string) (*muxRouter, error) {
+	r := &muxRouter{dir: dir}
+	if len(defaultCommand) > 0 {
+		r.defaultCommand = defaultCommand
+	}
+
+	filter, err := newMuxFilter(cmd, dir)
+	if err != nil {
+		return nil, err
+	}
+	r.filter = filter
+
+	if err := checkChildTemplates(r.defaultCommand...); err != nil {
+		return nil, err
+	}
+
+	specs, _ := cmd.Flags().GetStringArray("route")
+	if file, _ := cmd.Flags().GetString("routes-file"); file != "" {
+		fileSpecs, err := readRoutesFile(file)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, fileSpecs...)
+	}
+
+	for _, spec := range specs {
+		route, err := parseRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		r.routes = append(r.routes, route)
+	}
+
+	if r.routes == nil && r.defaultCommand == nil {
+		return nil, errors.New("a default command or at least one --route is required")
+	}
+	return r, nil
+}
+
+// readRoutesFile reads routes from a file, ignoring blank lines and lines
+// starting with '#'.
+func readRoutesFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var specs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			specs = append(specs, line)
+		}
+	}
+	return specs, scanner.Err()
+}
+
+// parseRoute parses a route of the form PATTERN=COMMAND. An empty command
+// ignores matching channels.
+func parseRoute(spec string) (muxRoute, error) {
+	i := routeSeparator(spec)
+	if i < 0 {
+		return muxRoute{}, fmt.Errorf("invalid route %q: expected PATTERN=COMMAND", spec)
+	}
+	pattern := strings.TrimSpace(spec[:i])
+
+	route := muxRoute{spec: spec}
+	command, err := splitCommandLine(spec[i+1:])
+	if err != nil {
+		return muxRoute{}, fmt.Errorf("invalid route %q: %v", spec, err)
+	}
+	if len(command) > 0 {
+		route.command = command
+	}
+	if err := checkChildTemplates(command...); err != nil {
+		return muxRoute{}, fmt.Errorf("invalid route %q: %v", spec, err)
+	}
+
+	switch {
+	case pattern == "*":
+		route.kind = "any"
+	case channelIDPattern.MatchString(pattern):
+		route.kind, route.value = "id", pattern
+	case strings.HasPrefix(pattern, "#"):
+		route.kind, route.value = "name", pattern[1:]
+	default:
+		j := strings.IndexByte(pattern, ':')
+		if j < 0 {
+			return muxRoute{}, fmt.Errorf("invalid route %q: unrecognized pattern %q", spec, pattern)
+		}
+		route.kind, route.value = pattern[:j], pattern[j+1:]
+	}
+
+	switch route.kind {
+	case "any", "id":
+	case "name":
+		if _, err := path.Match(route.value, ""); err != nil {
+			return muxRoute{}, fmt.Errorf("invalid route %q: %v", spec, err)
+		}
+	case "type":
+		switch route.value {
+		case directory.TypePublic, directory.TypePrivate, directory.TypeIM, directory.TypeMPIM:
+		default:
+			return muxRoute{}, fmt.Errorf("invalid route %q: unknown channel type %q", spec, route.value)
+		}
+	case "text":
+		if route.re, err = regexp.Compile(route.value); err != nil {
+			return muxRoute{}, fmt.Errorf("invalid route %q: %v", spec, err)
+		}
+	default:
+		return muxRoute{}, fmt.Errorf("invalid route %q: unknown pattern type %q", spec, route.kind)
+	}
+
+	return route, nil
+}
+
+// routeSeparator returns the index of the '=' separating the pattern of a
+// route from its command, or -1 if there is none. This is the first '=' not
+// escaped by a backslash: commands often contain '=' (as in --flag=value),
+// while patterns can only contain one in a text: regular expression, where
+// "\=" matches a literal '='.
+func routeSeparator(spec string) int {
+	escaped := false
+	for i := 0; i < len(spec); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case spec[i] == '\\':
+			escaped = true
+		case spec[i] == '=':
+			return i
+		}
+	}
+	return -1
+}
+
+// route returns the command line to run for the channel of the given message,
+// or nil if the channel should be ignored.
+func (r *muxRouter) route(msg slackio.Message) []string {
+	if r.filter != nil && !r.filter.accept(msg) {
+		return nil
+	}
+
+	for _, route := range r.routes {
+		if r.matches(route, msg) {
+			return route.command
+		}
+	}
+	return r.defaultCommand
+}
+
+func (r *muxRouter) matches(route muxRoute, msg slackio.Message) bool {
+	switch route.kind {
+	case "any":
+		return true
+	case "id":
+		return msg.ChannelID == route.value
+	case "text":
+		return route.re.MatchString(msg.Text)
+	}
+
+	ch, err := r.dir.Channel(msg.ChannelID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "slackbridge: route %q: %v\n", route.spec, err)
+		return false
+	}
+
+	if route.kind == "type" {
+		return ch.Type == route.value
+	}
+	matched, _ := path.Match(route.value, ch.Name)
+	return matched
+}
+
+// splitCommandLine splits a command line into words at unquoted whitespace.
+// Single quotes preserve their contents literally, while double quotes allow
+// backslash escapes of '"' and '\'. Outside of quotes, a backslash escapes any
+// character.
+func splitCommandLine(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inWord = true
+
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
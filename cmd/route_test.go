@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	testCases := []struct {
+		spec    string
+		kind    string
+		value   string
+		command []string
+	}{
+		{"*=./bot", "any", "", []string{"./bot"}},
+		{"C12345678=./bot {{.ChannelID}}", "id", "C12345678", []string{"./bot", "{{.ChannelID}}"}},
+		{"id:C12345678=./bot", "id", "C12345678", []string{"./bot"}},
+		{"#deploy-*=./deploy-bot", "name", "deploy-*", []string{"./deploy-bot"}},
+		{"name:ops-*=", "name", "ops-*", nil},
+		{"type:im=./dm-bot --mode=dm", "type", "im", []string{"./dm-bot", "--mode=dm"}},
+		{`text:a\=b=./bot`, "text", `a\=b`, []string{"./bot"}},
+		{`text:a\\=./bot`, "text", `a\\`, []string{"./bot"}},
+		{`text:^x\=\d+$=./bot --n=1`, "text", `^x\=\d+$`, []string{"./bot", "--n=1"}},
+	}
+
+	for _, tc := range testCases {
+		route, err := parseRoute(tc.spec)
+		if err != nil {
+			t.Errorf("parseRoute(%q): unexpected error: %v", tc.spec, err)
+			continue
+		}
+		if route.kind != tc.kind || route.value != tc.value || !reflect.DeepEqual(route.command, tc.command) {
+			t.Errorf("parseRoute(%q) = (%q, %q, %q); want (%q, %q, %q)",
+				tc.spec, route.kind, route.value, route.command, tc.kind, tc.value, tc.command)
+		}
+	}
+}
+
+func TestParseRouteTextMatchesEscapedEquals(t *testing.T) {
+	route, err := parseRoute(`text:a\=b=./bot`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !route.re.MatchString("a=b") {
+		t.Errorf("route does not match %q", "a=b")
+	}
+}
+
+func TestParseRouteErrors(t *testing.T) {
+	for _, spec := range []string{
+		"./bot",
+		`text:a\=b`,
+		"bogus=./bot",
+		"type:channel=./bot",
+		"text:(=./bot",
+		"#[=./bot",
+		`*='unterminated`,
+	} {
+		if _, err := parseRoute(spec); err == nil {
+			t.Errorf("parseRoute(%q): expected an error", spec)
+		}
+	}
+}
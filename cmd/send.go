@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+	"go.alexhamlin.co/slackbridge/internal/webclient"
+)
+
+// sendRateLimitRetries is the number of times that send will wait and retry a
+// message that Slack rejects due to rate limiting.
+const sendRateLimitRetries = 3
+
+var sendCmd = &cobra.Command{
+	Use:     "send [flags] [text...]",
+	Example: `send -c C12345678 "Deploy finished"` + "\n" + `  make test 2>&1 | send -c C12345678 --code`,
+	Short:   "Send a message to a Slack channel and wait for delivery",
+	Long: `Send posts a message to a single Slack channel through Slack's Web API
+(chat.postMessage) and exits once Slack has accepted it. The message text is
+taken from the arguments, joined with spaces, or from stdin if no arguments are
+given. The timestamp of each posted message is printed to stdout, which allows
+later messages to reply in its thread using --thread.
+
+Text longer than --max-length is split into multiple messages at line
+boundaries, and a code block in the text that is split is closed and reopened
+around each split. With --code, each message is wrapped in a code block.
+
+Send exits with status 0 if every message was delivered, 3 if Slack rejected
+the API credentials, and 1 for any other failure (in which case earlier parts
+of a split message may already have been delivered).`,
+
+	Run: runSendCmd,
+}
+
+func init() {
+	RootCmd.AddCommand(sendCmd)
+	flags := sendCmd.Flags()
+	flags.StringP("channel", "c", "", "ID of the channel to send to (required)")
+	sendCmd.MarkFlagRequired("channel")
+	flags.String("thread", "", "timestamp of a message to reply to in its thread")
+	flags.Bool("broadcast", false, "also send a thread reply to the channel (requires --thread)")
+	flags.Bool("code", false, "wrap the text in a code block")
+	flags.Int("max-length", 4000, "maximum length of each message, beyond which text is split (0 for no limit)")
+	addWebOptionFlags(sendCmd, "")
+}
+
+func runSendCmd(cmd *cobra.Command, args []string) {
+	apiToken := os.Getenv("SLACK_TOKEN")
+	if apiToken == "" {
+		fmt.Fprintln(os.Stderr, "Error: SLACK_TOKEN environment variable not set")
+		fmt.Fprintln(os.Stderr, RootCmd.UsageString())
+		os.Exit(1)
+	}
+
+	flags := cmd.Flags()
+	channelID, _ := flags.GetString("channel")
+	thread, _ := flags.GetString("thread")
+	broadcast, _ := flags.GetBool("broadcast")
+	code, _ := flags.GetBool("code")
+	maxLength, _ := flags.GetInt("max-length")
+
+	if broadcast && thread == "" {
+		exitWithError(errors.New("--broadcast requires --thread"))
+	}
+
+	text := strings.Join(args, " ")
+	if len(args) == 0 {
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			exitWithError(err)
+		}
+		text = string(input)
+	}
+
+	text = strings.TrimRight(text, "\r\n")
+	if strings.TrimSpace(text) == "" {
+		exitWithError(errors.New("no text to send"))
+	}
+
+	if code && maxLength > 0 {
+		// Leave room for the code block delimiters.
+		maxLength -= len(codeBlock(""))
+		if maxLength < 1 {
+			maxLength = 1
+		}
+	}
+
+	client := webclient.New(apiToken, webOptions(cmd))
+	for _, chunk := range splitText(text, maxLength) {
+		if code {
+			chunk = codeBlock(chunk)
+		}
+
+		ts, err := postWithRetry(client, slackio.Message{
+			ChannelID:       channelID,
+			Text:            chunk,
+			ThreadTimestamp: thread,
+			Broadcast:       broadcast,
+		})
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Println(ts)
+	}
+}
+
+// postWithRetry posts a message, waiting and retrying as Slack instructs if
+// the message is rate limited.
+func postWithRetry(client *webclient.Client, m slackio.Message) (string, error) {
+	for attempt := 0; ; attempt++ {
+		ts, err := client.PostMessage(m)
+		if rateErr, ok := err.(*slack.RateLimitedError); ok && attempt < sendRateLimitRetries {
+			fmt.Fprintf(os.Stderr, "slackbridge: rate limited, retrying in %v\n", rateErr.RetryAfter)
+			time.Sleep(rateErr.RetryAfter)
+			continue
+		}
+		return ts, err
+	}
+}
+
+// codeBlock wraps text in a Slack code block.
+func codeBlock(text string) string {
+	return "```\n" + text + "\n```"
+}
+
+// codeFence opens and closes a Slack code block.
+const codeFence = "```"
+
+// splitText splits text into chunks of at most maxLength characters, breaking
+// between lines where possible. Lines longer than maxLength are broken
+// arbitrarily. A code block that spans chunks is closed at the end of each
+// chunk and reopened at the start of the next, so that every chunk renders
+// correctly on its own. If maxLength is 0 or less, text is returned as a single
+// chunk.
+func splitText(text string, maxLength int) []string {
+	if maxLength <= 0 || utf8.RuneCountInString(text) <= maxLength {
+		return []string{text}
+	}
+
+	// Code blocks are only reopened if a chunk has room for some of their text
+	// between the fences.
+	closeFence := "\n" + codeFence
+	refence := maxLength > 2*len(closeFence)
+
+	var chunks, lines []string
+	var length int   // of the lines joined with newlines
+	var inBlock bool // whether the lines so far leave a code block open
+
+	flush := func() {
+		chunk := strings.Join(lines, "\n")
+		if inBlock && refence {
+			chunk += closeFence
+		}
+		chunks = append(chunks, chunk)
+		lines, length = nil, 0
+		if inBlock && refence {
+			lines, length = []string{codeFence}, len(codeFence)
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		after := inBlock != (strings.Count(line, codeFence)%2 == 1)
+
+		for {
+			var sep, reserve int
+			if len(lines) > 0 {
+				sep = 1
+			}
+			if after && refence {
+				reserve = len(closeFence)
+			}
+
+			lineLength := utf8.RuneCountInString(line)
+			if length+sep+lineLength+reserve <= maxLength {
+				lines = append(lines, line)
+				length += sep + lineLength
+				break
+			}
+
+			// A chunk holding nothing but the start of a code block is as good as
+			// empty.
+			empty := len(lines) == 0 ||
+				(len(lines) == 1 && inBlock && strings.TrimSpace(lines[0]) == codeFence)
+			if !empty {
+				flush()
+				continue
+			}
+
+			// The line doesn't fit even in an otherwise empty chunk.
+			if inBlock && refence {
+				reserve = len(closeFence)
+			}
+			cut := runeOffset(line, maxLength-length-sep-reserve)
+			lines = append(lines, line[:cut])
+			flush()
+			line = line[cut:]
+		}
+
+		inBlock = after
+	}
+
+	chunks = append(chunks, strings.Join(lines, "\n"))
+	return chunks
+}
+
+// runeOffset returns the byte offset of the nth rune in s.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
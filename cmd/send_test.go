@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+	testCases := []struct {
+		name      string
+		text      string
+		maxLength int
+		want      []string
+	}{
+		{"no limit", "hello\nworld", 0, []string{"hello\nworld"}},
+		{"under the limit", "hello", 6, []string{"hello"}},
+		{"at the limit", "hello", 5, []string{"hello"}},
+		{"over the limit", "hello!", 5, []string{"hello", "!"}},
+		{"between lines", "aa\nbb\ncc", 5, []string{"aa\nbb", "cc"}},
+		{"lines at the limit", "aaaaa\nbbbbb", 5, []string{"aaaaa", "bbbbb"}},
+		{"long line", "aaaaaaaaaaaa\nbb", 5, []string{"aaaaa", "aaaaa", "aa\nbb"}},
+		{"empty lines", "aa\n\n\nbb", 4, []string{"aa\n\n", "bb"}},
+
+		{"multi-byte at the limit", "ééééé", 5, []string{"ééééé"}},
+		{"multi-byte over the limit", "éééééé", 5, []string{"ééééé", "é"}},
+		{"multi-byte lines", "日本\n語の\nテキスト", 5, []string{"日本\n語の", "テキスト"}},
+		{"emoji", "😀😀😀😀😀", 2, []string{"😀😀", "😀😀", "😀"}},
+
+		{
+			name:      "code block within a chunk",
+			text:      "intro\n```\ncode\n```\nafter",
+			maxLength: 20,
+			want:      []string{"intro\n```\ncode\n```", "after"},
+		},
+		{
+			name:      "code block across chunks",
+			text:      "intro\n```\nline1\nline2\nline3\n```\nafter",
+			maxLength: 20,
+			want:      []string{"intro\n```\nline1\n```", "```\nline2\nline3\n```", "after"},
+		},
+		{
+			name:      "code block closing at the limit",
+			text:      "```\nline1\nline2\n```\nafter",
+			maxLength: 19,
+			want:      []string{"```\nline1\nline2\n```", "after"},
+		},
+		{
+			name:      "long line in a code block",
+			text:      "```\naaaaaaaaaaaaaa\n```",
+			maxLength: 15,
+			want:      []string{"```\naaaaaaa\n```", "```\naaaaaaa\n```"},
+		},
+		{
+			name:      "unterminated code block",
+			text:      "```\nline1\nline2",
+			maxLength: 14,
+			want:      []string{"```\nline1\n```", "```\nline2"},
+		},
+		{
+			name:      "too short to reopen code blocks",
+			text:      "```\naaaa\n```",
+			maxLength: 8,
+			want:      []string{"```\naaaa", "```"},
+		},
+		{
+			name:      "inline code block",
+			text:      "```inline```\naa\nbb",
+			maxLength: 15,
+			want:      []string{"```inline```\naa", "bb"},
+		},
+	}
+
+	for _, tc := range testCases {
+		got := splitText(tc.text, tc.maxLength)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: splitText(%q, %d) = %q; want %q", tc.name, tc.text, tc.maxLength, got, tc.want)
+			continue
+		}
+
+		// Code blocks are closed in every chunk if they are closed in the text,
+		// and there is room to reopen them.
+		balanced := strings.Count(tc.text, codeFence)%2 == 0 && tc.maxLength > 2*len("\n"+codeFence)
+		for _, chunk := range got {
+			if !utf8.ValidString(chunk) {
+				t.Errorf("%s: chunk %q is not valid UTF-8", tc.name, chunk)
+			}
+			if n := utf8.RuneCountInString(chunk); tc.maxLength > 0 && n > tc.maxLength {
+				t.Errorf("%s: chunk %q has %d characters; want at most %d", tc.name, chunk, n, tc.maxLength)
+			}
+			if balanced && strings.Count(chunk, codeFence)%2 != 0 {
+				t.Errorf("%s: chunk %q leaves a code block open", tc.name, chunk)
+			}
+		}
+	}
+}
@@ -0,0 +1,605 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/bridgeconfig"
+	"go.alexhamlin.co/slackbridge/internal/childproc"
+	"go.alexhamlin.co/slackbridge/internal/directory"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// serveCrashWindow is the crash window of mux bridges, as with mux
+// --crash-window.
+const serveCrashWindow = 10 * time.Second
+
+var serveCmd = &cobra.Command{
+	Use:     "serve --config FILE",
+	Example: "serve --config /etc/slackbridge/bridges.yaml",
+	Short:   "Run many bridges from a configuration file over a single connection",
+	Long: `Serve runs any number of bridges declared in a configuration file, all
+sharing a single connection to Slack. Each bridge has a unique name and one of
+the following modes:
+
+  exec    runs a command connected to a single channel, as with the exec
+          command, optionally restarting it when it exits
+  mux     runs a command for each channel (or for one channel), as with the
+          mux command
+  stream  appends the messages of one channel (or every channel) to a file,
+          or to stdout if the output is "-"
+
+The configuration file is written in YAML, or in JSON if its name ends with
+".json":
+
+  bridges:
+    - name: deploy-bot
+      mode: exec
+      channel: C12345678
+      command: [./deploy-bot, --verbose]
+      env:
+        DEPLOY_ENV: production
+      dir: /srv/deploy-bot
+      restart: on-failure     # or "never" (the default) or "always"
+      restart_delay: 10s
+    - name: archive
+      mode: stream
+      channel: C87654321
+      output: /var/log/slack/archive.log
+
+The restart policy of a mux bridge applies to each channel's child process, as
+with the --respawn flag of the mux command: after a child exits, it is started
+again on the next message from its channel, waiting restart_delay if it
+crashed within 10s of starting.
+
+On SIGHUP, the configuration file is read again. Bridges that were removed or
+changed are stopped, bridges that were added or changed are started, and
+unchanged bridges keep running undisturbed. Exec bridges whose commands have
+exited for good (according to their restart policies) are also started again.
+If the new configuration is invalid, it is reported and the current bridges are
+left running. On SIGINT or SIGTERM, every bridge is stopped and slackbridge
+exits.
+
+With --admin, the bridges and the children of mux and exec bridges can be
+inspected and controlled using "slackbridge ctl". The admin API is served on a
+Unix socket or a loopback address, unless SLACKBRIDGE_ADMIN_TOKEN is set to a
+token that every request must then present.
+
+The input, output, and template flags apply to every bridge.`,
+
+	Args: cobra.NoArgs,
+	Run:  runServeCmd,
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().String("config", "", "path to the bridge configuration file (required)")
+	serveCmd.MarkFlagRequired("config")
+	addInputFlags(serveCmd)
+	addOutputFlags(serveCmd)
+	addAdminFlags(serveCmd)
+}
+
+func runServeCmd(cmd *cobra.Command, args []string) {
+	apiToken := os.Getenv("SLACK_TOKEN")
+	if apiToken == "" {
+		fmt.Fprintln(os.Stderr, "Error: SLACK_TOKEN environment variable not set")
+		fmt.Fprintln(os.Stderr, RootCmd.UsageString())
+		os.Exit(1)
+	}
+
+	configPath, _ := cmd.Flags().GetString("config")
+	config, err := bridgeconfig.Load(configPath)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	readerOpts, err := readerOptions(cmd, apiToken, false)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	client, err := newClient(cmd, apiToken)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	writeClient, err := newWriteClient(cmd, apiToken, client)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	s := &server{
+		cmd:         cmd,
+		client:      client,
+		writeClient: writeClient,
+		readerOpts:  readerOpts,
+		dir:         directory.New(slack.New(apiToken)),
+		bridges:     make(map[string]*runningBridge),
+	}
+
+	// Register for signals before starting any bridges, so that an early SIGHUP
+	// doesn't terminate slackbridge.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	s.apply(config)
+
+	admin, err := startAdminServer(cmd, s.adminBridges)
+	if err != nil {
+		s.stopAll()
+		exitWithError(err)
+	}
+
+loop:
+	for {
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				break loop
+			}
+
+			config, err := bridgeconfig.Load(configPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "slackbridge: failed to reload configuration, keeping the current bridges: %v\n", err)
+				continue
+			}
+			s.apply(config)
+
+		case <-client.Failed():
+			break loop
+		}
+	}
+
+	admin.Close()
+	s.stopAll()
+	client.Close()
+
+	if err := client.Err(); err != nil {
+		exitWithError(err)
+	}
+}
+
+// server manages the bridges run by the serve command.
+type server struct {
+	cmd         *cobra.Command
+	client      *slackio.Client
+	writeClient slackio.WriteClient
+	readerOpts  slackio.ReaderOptions
+	dir         *directory.Directory
+
+	mu      sync.Mutex // guards bridges, for the admin API
+	bridges map[string]*runningBridge
+}
+
+// runningBridge is a bridge started by a server.
+type runningBridge struct {
+	config   bridgeconfig.Bridge
+	stop     func()          // stops the bridge, and waits for it to finish
+	children childController // for mux and exec bridges
+}
+
+// apply brings the running bridges in line with config, leaving unchanged
+// bridges running.
+func (s *server) apply(config *bridgeconfig.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := make(map[string]bridgeconfig.Bridge, len(config.Bridges))
+	for _, b := range config.Bridges {
+		wanted[b.Name] = b
+	}
+
+	var stopped, unchanged int
+	for name, rb := range s.bridges {
+		if b, ok := wanted[name]; ok && reflect.DeepEqual(b, rb.config) {
+			unchanged++
+			continue
+		}
+		s.logf(name, "stopping")
+		rb.stop()
+		delete(s.bridges, name)
+		stopped++
+	}
+
+	var started int
+	for _, b := range config.Bridges {
+		if _, ok := s.bridges[b.Name]; ok {
+			continue
+		}
+
+		rb, err := s.start(b)
+		if err != nil {
+			s.logf(b.Name, "failed to start: %v", err)
+			continue
+		}
+		s.bridges[b.Name] = rb
+		started++
+	}
+
+	fmt.Fprintf(os.Stderr, "slackbridge: configuration applied: %d bridge(s) started, %d stopped, %d unchanged\n", started, stopped, unchanged)
+}
+
+func (s *server) stopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, rb := range s.bridges {
+		wg.Add(1)
+		go func(name string, rb *runningBridge) {
+			defer wg.Done()
+			s.logf(name, "stopping")
+			rb.stop()
+		}(name, rb)
+	}
+	wg.Wait()
+}
+
+// adminBridges lists the running bridges for the admin API.
+func (s *server) adminBridges() []adminBridge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bridges := make([]adminBridge, 0, len(s.bridges))
+	for name, rb := range s.bridges {
+		bridges = append(bridges, adminBridge{name: name, mode: rb.config.Mode, children: rb.children})
+	}
+	sort.Slice(bridges, func(i, j int) bool { return bridges[i].name < bridges[j].name })
+	return bridges
+}
+
+func (s *server) logf(name, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "slackbridge: bridge %q: %s\n", name, fmt.Sprintf(format, args...))
+}
+
+func (s *server) start(b bridgeconfig.Bridge) (*runningBridge, error) {
+	switch b.Mode {
+	case bridgeconfig.ModeExec:
+		return s.startExec(b), nil
+	case bridgeconfig.ModeMux:
+		return s.startMux(b)
+	case bridgeconfig.ModeStream:
+		return s.startStream(b)
+	default:
+		// Load has already validated the mode.
+		return nil, errors.New("unknown mode")
+	}
+}
+
+// startExec starts a bridge that runs a child process connected to a single
+// channel, restarting it according to the bridge's restart policy.
+func (s *server) startExec(b bridgeconfig.Bridge) *runningBridge {
+	e := &execBridge{
+		server:   s,
+		config:   b,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		wake:     make(chan struct{}, 1),
+		exitCode: -1,
+	}
+	rb := &runningBridge{config: b, stop: e.stop, children: e}
+	go func() {
+		defer func() {
+			// If the bridge finished on its own, it is no longer running, and
+			// the next configuration reload should start it again. done must be
+			// closed first, as apply and stopAll hold s.mu while stopping bridges.
+			close(e.done)
+			select {
+			case <-e.quit:
+			default:
+				s.forget(b.Name, rb)
+			}
+		}()
+		e.run()
+	}()
+	return rb
+}
+
+// execBridge runs the child process of an exec bridge, and allows it to be
+// inspected and controlled through the admin API.
+type execBridge struct {
+	server *server
+	config bridgeconfig.Bridge
+	quit   chan struct{} // closed to stop the bridge
+	done   chan struct{} // closed once the bridge has stopped
+	wake   chan struct{} // cuts short the delay before a restart
+
+	mu         sync.Mutex
+	proc       *childproc.Process // nil unless running
+	pid        int                // of the current or last process
+	started    time.Time
+	exitCode   int
+	failed     bool         // the last process could not be started
+	retryAt    time.Time    // when the next process starts, after an exit
+	stream     *childStream // of the current, last, or starting process
+	paused     bool
+	restarting bool // the current process is being stopped to be restarted
+}
+
+// run starts the child process, and starts it again after it exits as the
+// restart policy requires, until the bridge is stopped.
+func (e *execBridge) run() {
+	b := e.config
+	s := e.server
+	opts := childproc.Options{Env: b.Environ(os.Environ()), Dir: b.Dir}
+
+	for {
+		e.mu.Lock()
+		stream := newChildStream(e.paused)
+		e.stream = stream
+		e.mu.Unlock()
+
+		reader := stream.input(slackio.NewReaderWithOptions(newSubscriber(s.cmd, s.client, -1, b.Channel, s.writeClient), b.Channel, s.readerOpts))
+		writer := stream.output(slackio.NewWriter(s.writeClient, b.Channel, nil))
+
+		code := -1
+		proc, err := childproc.SpawnWithOptions(b.Command, reader, writer, opts)
+		if err != nil {
+			s.logf(b.Name, "failed to start child process: %v", err)
+			reader.Close()
+			writer.Close()
+			e.mu.Lock()
+			e.failed, e.exitCode = true, -1
+			e.mu.Unlock()
+		} else {
+			e.mu.Lock()
+			e.proc, e.pid, e.failed = proc, proc.Pid(), false
+			e.started = time.Now()
+			select {
+			case <-e.quit:
+				// We were stopped while spawning the child.
+				go terminateChild(proc)
+			default:
+			}
+			e.mu.Unlock()
+
+			s.logf(b.Name, "started child process %d", proc.Pid())
+			code = proc.ExitCode()
+			s.logf(b.Name, "child process %d exited with status %d", proc.Pid(), code)
+		}
+
+		e.mu.Lock()
+		e.proc, e.exitCode = nil, code
+		restarting := e.restarting
+		e.restarting = false
+		e.mu.Unlock()
+
+		select {
+		case <-e.quit:
+			return
+		default:
+		}
+
+		if restarting {
+			continue
+		}
+		if b.Restart == bridgeconfig.RestartNever || (b.Restart == bridgeconfig.RestartOnFailure && code == 0) {
+			return
+		}
+
+		delay := time.Duration(b.RestartDelay)
+		s.logf(b.Name, "restarting in %v", delay)
+		e.mu.Lock()
+		e.retryAt = time.Now().Add(delay)
+		e.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-e.quit:
+			timer.Stop()
+			return
+		case <-e.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		e.mu.Lock()
+		e.retryAt = time.Time{}
+		select {
+		case <-e.wake:
+			// A restart raced with the end of the delay.
+		default:
+		}
+		e.mu.Unlock()
+	}
+}
+
+// stop stops the bridge, terminating its child process, and waits for it to
+// finish.
+func (e *execBridge) stop() {
+	e.mu.Lock()
+	close(e.quit)
+	proc := e.proc
+	e.mu.Unlock()
+
+	if proc != nil {
+		terminateChild(proc)
+	}
+	<-e.done
+}
+
+func (e *execBridge) list() []childInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	info := childInfo{
+		ChannelID: e.config.Channel,
+		PID:       e.pid,
+		Started:   e.started,
+		ExitCode:  e.exitCode,
+		Paused:    e.paused,
+	}
+	switch {
+	case e.proc != nil && e.restarting:
+		info.State, info.ExitCode = childStopping, -1
+	case e.proc != nil:
+		info.State, info.ExitCode = childRunning, -1
+	case !e.retryAt.IsZero():
+		info.State = childBackoff
+	case e.failed:
+		info.State = childFailed
+	default:
+		info.State = childExited
+	}
+	if e.stream != nil {
+		info.BytesIn, info.BytesOut, info.LastActivity = e.stream.stats()
+	}
+	return []childInfo{info}
+}
+
+func (e *execBridge) signalChild(channelID string, sig os.Signal) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if channelID != e.config.Channel || e.proc == nil {
+		return fmt.Errorf("no child process is running for %s", channelID)
+	}
+	e.server.logf(e.config.Name, "sending %v to child process %d", sig, e.pid)
+	return e.proc.Signal(sig)
+}
+
+// restartChild stops the child process if it is running, or cuts short the
+// delay before it restarts, and starts it again without regard to the
+// bridge's restart policy.
+func (e *execBridge) restartChild(channelID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if channelID != e.config.Channel {
+		return fmt.Errorf("no child process has been started for %s", channelID)
+	}
+
+	switch {
+	case !e.retryAt.IsZero():
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	case e.proc == nil:
+		return fmt.Errorf("the child process for %s is starting", channelID)
+	case !e.restarting:
+		e.restarting = true
+		go terminateChild(e.proc)
+	}
+	e.server.logf(e.config.Name, "restarting the child process")
+	return nil
+}
+
+// pauseChild pauses or resumes the input of the child process. The setting
+// also applies to any later child process of the bridge.
+func (e *execBridge) pauseChild(channelID string, paused bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if channelID != e.config.Channel {
+		return fmt.Errorf("no child process has been started for %s", channelID)
+	}
+
+	e.paused = paused
+	if e.stream != nil {
+		e.stream.setPaused(paused)
+	}
+	action := "resumed"
+	if paused {
+		action = "paused"
+	}
+	e.server.logf(e.config.Name, "input %s", action)
+	return nil
+}
+
+// forget removes a bridge that has finished on its own from the running set,
+// unless it has already been replaced.
+func (s *server) forget(name string, rb *runningBridge) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bridges[name] == rb {
+		delete(s.bridges, name)
+		s.logf(name, "finished; it will be started again when the configuration is reloaded")
+	}
+}
+
+// startMux starts a bridge that runs a child process for each channel. As with
+// the mux command, the bridge's command, environment values, and working
+// directory are templates.
+func (s *server) startMux(b bridgeconfig.Bridge) (*runningBridge, error) {
+	spec := muxChildSpec{Dir: b.Dir}
+	for k, v := range b.Env {
+		spec.Env = append(spec.Env, k+"="+v)
+	}
+	sort.Strings(spec.Env)
+	if err := spec.check(); err != nil {
+		return nil, err
+	}
+	if err := checkChildTemplates(b.Command...); err != nil {
+		return nil, err
+	}
+
+	router := &muxRouter{dir: s.dir, defaultCommand: b.Command}
+	if b.Channel != "" {
+		router.filter = &messageFilter{dir: s.dir, channels: stringSet([]string{b.Channel})}
+	}
+	m := newMuxer(s.cmd, s.client, s.writeClient, nil, s.readerOpts, router, spec, serveMuxPolicy(b))
+	m.start()
+	return &runningBridge{config: b, stop: m.stop, children: m}, nil
+}
+
+// serveMuxPolicy returns the muxPolicy of a mux bridge. Children that crash
+// are restarted after the bridge's restart delay, for as long as they keep
+// crashing.
+func serveMuxPolicy(b bridgeconfig.Bridge) muxPolicy {
+	delay := time.Duration(b.RestartDelay)
+	return muxPolicy{
+		Respawn:             b.Restart != bridgeconfig.RestartNever,
+		RespawnOnlyFailures: b.Restart == bridgeconfig.RestartOnFailure,
+		Backoff:             delay,
+		MaxBackoff:          delay,
+		CrashWindow:         serveCrashWindow,
+	}
+}
+
+// startStream starts a bridge that appends the messages of one or more
+// channels to a file.
+func (s *server) startStream(b bridgeconfig.Bridge) (*runningBridge, error) {
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if b.Output != "-" {
+		var err error
+		if file, err = os.OpenFile(b.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+			return nil, err
+		}
+		out = file
+	}
+
+	reader := slackio.NewReaderWithOptions(newSubscriber(s.cmd, s.client, -1, b.Channel, nil), b.Channel, s.readerOpts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := io.Copy(out, reader); err != nil {
+			s.logf(b.Name, "failed to write output: %v", err)
+		}
+	}()
+
+	return &runningBridge{
+		config: b,
+		stop: func() {
+			reader.Close()
+			<-done
+			if file != nil {
+				file.Close()
+			}
+		},
+	}, nil
+}
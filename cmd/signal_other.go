@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// statusSignals are the signals on which mux logs the state of its children.
+var statusSignals = []os.Signal{syscall.SIGUSR1}
+
+// platformSignals are the signals that may be sent to children through the
+// admin API by name, in addition to those available on every platform.
+var platformSignals = map[string]os.Signal{
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"STOP": syscall.SIGSTOP,
+	"CONT": syscall.SIGCONT,
+}
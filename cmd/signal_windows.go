@@ -0,0 +1,11 @@
+package cmd
+
+import "os"
+
+// statusSignals are the signals on which mux logs the state of its children.
+// Windows has no suitable signal.
+var statusSignals []os.Signal
+
+// platformSignals are the signals that may be sent to children through the
+// admin API by name, in addition to those available on every platform.
+var platformSignals = map[string]os.Signal{}
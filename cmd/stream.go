@@ -6,23 +6,30 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
-	"go.alexhamlin.co/slackio"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
 )
 
 var streamCmd = &cobra.Command{
 	Use:   "stream",
 	Short: "Stream one or more Slack channels to stdout",
 	Long: `Stream connects to Slack and continuously streams the main body of one
-or more channels (i.e. excluding threads) to standard output. By default, the
-text of all of the user's channels will be streamed together with no
-identification of any message's originating channel. If desired, output can be
-filtered to a single channel.`,
+or more channels (i.e. excluding threads, unless --include-threads is given) to
+standard output. By default, the text of all of the user's channels will be
+streamed together with no identification of any message's originating channel;
+use --template to identify the channel and user of each line.
+
+Output can be filtered by channel, channel type, user, and message content.
+Filters apply to whole messages, so multi-line messages are never split. Each
+kind of filter that is provided must match for a message to be output.`,
 	Run: runStreamCmd,
 }
 
 func init() {
 	RootCmd.AddCommand(streamCmd)
-	streamCmd.Flags().StringP("channel", "c", "", "only output messages from the provided channel ID")
+	addFilterFlags(streamCmd)
+	addInputFlags(streamCmd)
+	addCheckpointFlags(streamCmd)
 }
 
 func runStreamCmd(cmd *cobra.Command, args []string) {
@@ -33,15 +40,44 @@ func runStreamCmd(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	slackChannel, _ := cmd.Flags().GetString("channel")
+	readerOpts, err := readerOptions(cmd, apiToken, true)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	store, err := openCheckpoints(cmd)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	positions, err := replayPositions(cmd, apiToken, store, filterChannels(cmd))
+	if err != nil {
+		exitWithError(err)
+	}
 
-	client := slackio.NewClient(apiToken)
+	client, err := newClient(cmd, apiToken)
+	if err != nil {
+		exitWithError(err)
+	}
 	defer client.Close()
 
-	reader := slackio.NewReader(client, slackChannel)
+	reader := slackio.NewReaderWithOptions(newSubscriber(cmd, client, -1, "", nil), "", checkpointReaderOptions(readerOpts, store))
 	defer reader.Close()
 
+	go replay(client, positions)
+
+	// If the Client fails, closing the Reader ends the stream.
+	go func() {
+		<-client.Failed()
+		reader.Close()
+	}()
+
 	if _, err := io.Copy(os.Stdout, reader); err != nil {
 		panic(err)
 	}
+
+	if err := client.Err(); err != nil {
+		client.Close()
+		exitWithError(err)
+	}
 }
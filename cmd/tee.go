@@ -0,0 +1,335 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+	"go.alexhamlin.co/slackbridge/internal/webclient"
+)
+
+var teeCmd = &cobra.Command{
+	Use:     "tee",
+	Example: "make 2>&1 | tee -c C12345678 --match 'error|warning' -C 2",
+	Short:   "Copy stdin to stdout while also sending it to a Slack channel",
+	Long: `Tee copies its standard input to standard output unchanged, and sends each
+line to a single Slack channel through Slack's Web API (chat.postMessage).
+Lines are batched into messages as with the exec command.
+
+With --match, only lines matching one of the given regular expressions are
+sent, along with any context lines requested by --context, --before-context,
+and --after-context. As with grep, non-adjacent groups of lines are separated
+by "--".
+
+Tee never holds up the pipeline for Slack. Lines waiting to be sent are held
+in a queue of --queue-size lines, and lines that do not fit are dropped with a
+notice in the channel and on stderr. Messages that Slack rate limits are
+retried, holding lines in the queue, while lines in messages that fail for
+other reasons are reported on stderr. Once input ends, tee waits up to
+--flush-timeout for queued lines to be sent, optionally sends a summary with
+--summary, and exits.
+If Slack rejected the API credentials, tee stops sending but continues to copy
+its input, and exits with status 3 once input ends.`,
+
+	Args: cobra.NoArgs,
+	Run:  runTeeCmd,
+}
+
+func init() {
+	RootCmd.AddCommand(teeCmd)
+	flags := teeCmd.Flags()
+	flags.StringP("channel", "c", "", "ID of the channel to send to (required)")
+	teeCmd.MarkFlagRequired("channel")
+	flags.StringArray("match", nil, "only send lines matching the provided regular expression (repeatable, any may match)")
+	flags.IntP("context", "C", 0, "with --match, also send this many lines before and after each matching line")
+	flags.IntP("before-context", "B", 0, "with --match, also send this many lines before each matching line")
+	flags.IntP("after-context", "A", 0, "with --match, also send this many lines after each matching line")
+	flags.Int("queue-size", 1000, "maximum number of lines waiting to be sent, beyond which lines are dropped")
+	flags.Duration("flush-timeout", 10*time.Second, "once input ends, how long to wait for queued lines to be sent")
+	flags.Bool("summary", false, "once input ends, send a summary of the number of lines read, matched, and queued to send")
+	addWebOptionFlags(teeCmd, "")
+}
+
+func runTeeCmd(cmd *cobra.Command, args []string) {
+	apiToken := os.Getenv("SLACK_TOKEN")
+	if apiToken == "" {
+		fmt.Fprintln(os.Stderr, "Error: SLACK_TOKEN environment variable not set")
+		fmt.Fprintln(os.Stderr, RootCmd.UsageString())
+		os.Exit(1)
+	}
+
+	flags := cmd.Flags()
+	channelID, _ := flags.GetString("channel")
+	queueSize, _ := flags.GetInt("queue-size")
+	flushTimeout, _ := flags.GetDuration("flush-timeout")
+	summary, _ := flags.GetBool("summary")
+
+	match, err := compileRegexps(cmd, "match")
+	if err != nil {
+		exitWithError(err)
+	}
+
+	before, after := teeContext(cmd)
+	if queueSize < 1 {
+		exitWithError(fmt.Errorf("invalid --queue-size value %d", queueSize))
+	}
+
+	sink := newTeeSink(apiToken, channelID, webOptions(cmd), queueSize)
+	filter := &teeFilter{match: match, before: before, after: after}
+
+	var lines, matched int
+	var partial []byte
+	handleLine := func(line string) {
+		lines++
+		send, isMatch := filter.add(line)
+		if isMatch {
+			matched++
+		}
+		for _, l := range send {
+			sink.enqueue(l)
+		}
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := os.Stdin.Read(buf)
+		if n > 0 {
+			if _, err := os.Stdout.Write(buf[:n]); err != nil {
+				exitWithError(err)
+			}
+
+			partial = append(partial, buf[:n]...)
+			for {
+				i := bytes.IndexByte(partial, '\n')
+				if i < 0 {
+					break
+				}
+				handleLine(string(partial[:i]))
+				partial = partial[i+1:]
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			exitWithError(readErr)
+		}
+	}
+	if len(partial) > 0 {
+		handleLine(string(partial))
+	}
+
+	if summary {
+		text := fmt.Sprintf("_slackbridge: input ended after %d lines", lines)
+		if match != nil {
+			text += fmt.Sprintf(", %d of which matched", matched)
+		}
+		// Lines are still being sent at this point, and may never be if the flush
+		// times out, so only the number queued is known.
+		text += fmt.Sprintf("; %d lines queued to send", sink.queued)
+		if dropped := sink.dropped; dropped > 0 {
+			text += fmt.Sprintf(", %d dropped", dropped)
+		}
+		if failed := sink.failedLines(); failed > 0 {
+			text += fmt.Sprintf(", %d failed to send", failed)
+		}
+		sink.enqueue(text + "_")
+	}
+
+	if !sink.close(flushTimeout) {
+		fmt.Fprintf(os.Stderr, "slackbridge: gave up waiting after %v for queued lines to be sent\n", flushTimeout)
+	}
+	if sink.dropped > 0 || sink.failedLines() > 0 {
+		fmt.Fprintf(os.Stderr, "slackbridge: %d of %d lines were dropped, and %d failed to send\n", sink.dropped, sink.queued+sink.dropped, sink.failedLines())
+	}
+	if sink.authFailed() {
+		os.Exit(exitInvalidAuth)
+	}
+}
+
+// teeContext returns the number of context lines to send before and after
+// each matching line.
+func teeContext(cmd *cobra.Command) (before, after int) {
+	flags := cmd.Flags()
+	before, _ = flags.GetInt("context")
+	after = before
+	if flags.Changed("before-context") {
+		before, _ = flags.GetInt("before-context")
+	}
+	if flags.Changed("after-context") {
+		after, _ = flags.GetInt("after-context")
+	}
+	return before, after
+}
+
+// teeFilter selects the lines of input to send, in the manner of grep's
+// context options.
+type teeFilter struct {
+	match         []*regexp.Regexp
+	before, after int
+
+	history   []string // up to before unsent lines preceding the current one
+	remaining int      // lines after a match still to be sent
+	skipped   bool     // whether lines were skipped since the last sent line
+	started   bool     // whether any line has been sent
+}
+
+// add processes the next line of input, and returns the lines to send as a
+// result (possibly including earlier context lines) and whether the line
+// matched. If the filter has no expressions, every line is sent.
+func (f *teeFilter) add(line string) (send []string, matched bool) {
+	if f.match == nil {
+		return []string{line}, false
+	}
+
+	if anyMatch(f.match, line) {
+		if f.skipped && f.started {
+			send = append(send, "--")
+		}
+		send = append(send, f.history...)
+		send = append(send, line)
+		f.history = f.history[:0]
+		f.remaining = f.after
+		f.skipped, f.started = false, true
+		return send, true
+	}
+
+	if f.remaining > 0 {
+		f.remaining--
+		return []string{line}, false
+	}
+
+	if f.before > 0 {
+		if len(f.history) == f.before {
+			f.history = f.history[1:]
+			f.skipped = true
+		}
+		f.history = append(f.history, line)
+	} else {
+		f.skipped = true
+	}
+	return nil, false
+}
+
+// teeSink sends lines to Slack through a slackio.Writer, without ever
+// blocking the caller. Lines are queued until the Writer accepts them, and
+// dropped if the queue is full. Only one goroutine may enqueue lines.
+//
+// teeSink is also the Writer's slackio.WriteClient. It waits out Slack's rate
+// limits, so that lines back up in the queue rather than being lost in a
+// failed request.
+type teeSink struct {
+	client *webclient.Client
+	queue  chan string
+	done   chan struct{}
+
+	queued, dropped int // lines (excluding notices) queued and dropped
+	pendingDrops    int // lines dropped since the last notice
+
+	failed int64 // lines that Slack never accepted, accessed atomically
+
+	authOnce sync.Once
+	authErr  int32 // accessed atomically
+}
+
+func newTeeSink(apiToken, channelID string, opts webclient.Options, queueSize int) *teeSink {
+	s := &teeSink{
+		client: webclient.New(apiToken, opts),
+		queue:  make(chan string, queueSize),
+		done:   make(chan struct{}),
+	}
+
+	writer := slackio.NewWriter(s, channelID, nil)
+	go func() {
+		defer close(s.done)
+		for line := range s.queue {
+			if s.authFailed() {
+				continue
+			}
+			writer.Write([]byte(line + "\n"))
+		}
+		writer.Close()
+	}()
+
+	return s
+}
+
+// enqueue queues a line to be sent, or drops it if the queue is full. After
+// lines have been dropped, a notice is queued ahead of the next line that fits.
+func (s *teeSink) enqueue(line string) {
+	if s.authFailed() {
+		return
+	}
+
+	if s.pendingDrops > 0 {
+		notice := fmt.Sprintf("_slackbridge: %d lines were dropped because Slack fell behind_", s.pendingDrops)
+		select {
+		case s.queue <- notice:
+			fmt.Fprintf(os.Stderr, "slackbridge: dropped %d lines because Slack fell behind\n", s.pendingDrops)
+			s.pendingDrops = 0
+		default:
+		}
+	}
+
+	select {
+	case s.queue <- line:
+		s.queued++
+	default:
+		if s.pendingDrops == 0 {
+			fmt.Fprintln(os.Stderr, "slackbridge: send queue is full; dropping lines until Slack catches up")
+		}
+		s.dropped++
+		s.pendingDrops++
+	}
+}
+
+// SendMessage implements slackio.WriteClient, retrying messages that Slack
+// rate limits.
+func (s *teeSink) SendMessage(m slackio.Message) {
+	_, err := postWithRetry(s.client, m)
+	if err == nil {
+		return
+	}
+
+	if !slackio.IsAuthError(err) {
+		n := strings.Count(m.Text, "\n") + 1
+		atomic.AddInt64(&s.failed, int64(n))
+		fmt.Fprintf(os.Stderr, "slackbridge: failed to send %d lines: %v\n", n, err)
+		return
+	}
+	s.authOnce.Do(func() {
+		fmt.Fprintln(os.Stderr, "slackbridge: Slack rejected the API credentials; no more lines will be sent")
+		atomic.StoreInt32(&s.authErr, 1)
+	})
+}
+
+// close stops accepting lines and waits up to timeout for queued lines to be
+// sent, reporting whether they were.
+func (s *teeSink) close(timeout time.Duration) bool {
+	close(s.queue)
+	select {
+	case <-s.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (s *teeSink) authFailed() bool {
+	return atomic.LoadInt32(&s.authErr) != 0
+}
+
+// failedLines returns the number of lines that Slack has failed to accept.
+func (s *teeSink) failedLines() int {
+	return int(atomic.LoadInt64(&s.failed))
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestTeeFilter(t *testing.T) {
+	x := []*regexp.Regexp{regexp.MustCompile("x")}
+
+	testCases := []struct {
+		name          string
+		match         []*regexp.Regexp
+		before, after int
+		lines         []string
+		want          []string
+		matched       int
+	}{
+		{
+			name:  "no expressions",
+			lines: []string{"a", "x", "b"},
+			want:  []string{"a", "x", "b"},
+		},
+		{
+			name:    "no matches",
+			match:   x,
+			before:  1,
+			after:   1,
+			lines:   []string{"a", "b", "c"},
+			want:    nil,
+			matched: 0,
+		},
+		{
+			name:    "no context",
+			match:   x,
+			lines:   []string{"a", "x1", "b", "x2", "x3", "c"},
+			want:    []string{"x1", "--", "x2", "x3"},
+			matched: 3,
+		},
+		{
+			name:    "before context",
+			match:   x,
+			before:  1,
+			lines:   []string{"a", "b", "x1", "c", "d", "x2"},
+			want:    []string{"b", "x1", "--", "d", "x2"},
+			matched: 2,
+		},
+		{
+			name:    "after context",
+			match:   x,
+			after:   1,
+			lines:   []string{"x1", "a", "b", "x2", "c"},
+			want:    []string{"x1", "a", "--", "x2", "c"},
+			matched: 2,
+		},
+		{
+			name:    "adjacent contexts",
+			match:   x,
+			before:  1,
+			after:   1,
+			lines:   []string{"x1", "a", "b", "x2"},
+			want:    []string{"x1", "a", "b", "x2"},
+			matched: 2,
+		},
+		{
+			name:    "overlapping contexts",
+			match:   x,
+			before:  2,
+			after:   2,
+			lines:   []string{"a", "x1", "b", "x2", "c", "d", "e", "f", "g"},
+			want:    []string{"a", "x1", "b", "x2", "c", "d"},
+			matched: 2,
+		},
+		{
+			name:    "fewer lines than the before context",
+			match:   x,
+			before:  3,
+			lines:   []string{"a", "x1"},
+			want:    []string{"a", "x1"},
+			matched: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		f := &teeFilter{match: tc.match, before: tc.before, after: tc.after}
+		var got []string
+		var matched int
+		for _, line := range tc.lines {
+			send, isMatch := f.add(line)
+			got = append(got, send...)
+			if isMatch {
+				matched++
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: sent %q; want %q", tc.name, got, tc.want)
+		}
+		if matched != tc.matched {
+			t.Errorf("%s: %d lines matched; want %d", tc.name, matched, tc.matched)
+		}
+	}
+}
+
+func TestTeeSinkDrops(t *testing.T) {
+	// Without a goroutine to send them, lines stay in the queue.
+	s := &teeSink{queue: make(chan string, 2)}
+	for _, line := range []string{"a", "b", "c", "d", "e"} {
+		s.enqueue(line)
+	}
+	if s.queued != 2 || s.dropped != 3 {
+		t.Errorf("queued %d and dropped %d lines; want 2 and 3", s.queued, s.dropped)
+	}
+
+	<-s.queue
+	<-s.queue
+	s.enqueue("f")
+	var got []string
+	for len(s.queue) > 0 {
+		got = append(got, <-s.queue)
+	}
+	want := []string{"_slackbridge: 3 lines were dropped because Slack fell behind_", "f"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queue holds %q; want %q", got, want)
+	}
+	if s.queued != 3 || s.dropped != 3 || s.pendingDrops != 0 {
+		t.Errorf("queued %d, dropped %d, and pending %d lines; want 3, 3, and 0", s.queued, s.dropped, s.pendingDrops)
+	}
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/directory"
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// addRenderFlags adds flags to the given command that control how received
+// messages are rendered as text. See readerOptions and threadPrefix.
+func addRenderFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("template", "", `Go template for each line of received text, e.g. "{{.Channel}} {{.User}}: {{.Text}}" (available: .Text, .Channel, .ChannelID, .ChannelType, .User, .UserID, .Time, .Timestamp, .ThreadTimestamp, .Broadcast)`)
+	flags.Bool("include-threads", false, "include thread replies in addition to the main body of each channel")
+	flags.String("thread-prefix", "[thread {{.ThreadTimestamp}}] ", "template prepended to each line of a thread reply with --include-threads")
+	flags.String("broadcast-prefix", "[thread {{.ThreadTimestamp}}, also in channel] ", "template prepended to each line of a thread reply that was also sent to the channel")
+}
+
+// threadPrefix returns a function implementing slackio.ReaderOptions.ThreadPrefix
+// using the templates selected by the flags added through addRenderFlags.
+func threadPrefix(cmd *cobra.Command, dir *directory.Directory) (func(slackio.Message) string, error) {
+	threadPrefix, err := parseMessageTemplate(cmd, "thread-prefix", dir)
+	if err != nil {
+		return nil, err
+	}
+	broadcastPrefix, err := parseMessageTemplate(cmd, "broadcast-prefix", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(m slackio.Message) string {
+		prefix := threadPrefix
+		if m.Broadcast {
+			prefix = broadcastPrefix
+		}
+		if prefix == nil {
+			return ""
+		}
+		return prefix.render(m)
+	}, nil
+}
+
+// templateMessage is the data with which message templates (e.g. --template)
+// are executed. In addition to the fields of slackio.Message, it provides
+// methods that resolve channel and user names, so that only templates which
+// use names incur the cost of looking them up. Names that can't be resolved
+// fall back to the corresponding IDs.
+type templateMessage struct {
+	slackio.Message
+	dir *directory.Directory
+}
+
+// Channel returns the name of the message's channel.
+func (m templateMessage) Channel() string {
+	if ch, ok := m.channel(); ok {
+		return ch.Name
+	}
+	return m.ChannelID
+}
+
+// ChannelType returns the type of the message's channel ("public", "private",
+// "im", or "mpim"), or a blank string if it is unknown.
+func (m templateMessage) ChannelType() string {
+	ch, _ := m.channel()
+	return ch.Type
+}
+
+// User returns the name of the user who sent the message.
+func (m templateMessage) User() string {
+	if m.dir != nil && m.UserID != "" {
+		if user, err := m.dir.User(m.UserID); err == nil {
+			return user.Name
+		}
+	}
+	return m.UserID
+}
+
+// Time returns the time at which the message was sent.
+func (m templateMessage) Time() time.Time {
+	return timestampTime(m.Timestamp)
+}
+
+func (m templateMessage) channel() (directory.Channel, bool) {
+	if m.dir == nil || m.ChannelID == "" {
+		return directory.Channel{}, false
+	}
+	ch, err := m.dir.Channel(m.ChannelID)
+	return ch, err == nil
+}
+
+// messageTemplate renders slackio Messages using a template provided through a
+// command line flag.
+type messageTemplate struct {
+	tmpl *template.Template
+	dir  *directory.Directory
+}
+
+// parseMessageTemplate parses the template provided through the named flag.
+// It returns nil if the flag is blank.
+func parseMessageTemplate(cmd *cobra.Command, name string, dir *directory.Directory) (*messageTemplate, error) {
+	text, _ := cmd.Flags().GetString(name)
+	if text == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err == nil {
+		// Catch references to nonexistent fields before any message arrives.
+		err = tmpl.Execute(ioutil.Discard, templateMessage{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", name, err)
+	}
+
+	return &messageTemplate{tmpl: tmpl, dir: dir}, nil
+}
+
+// render executes the template with the given message. Errors are printed to
+// stderr, along with whatever output the template produced before failing.
+func (t *messageTemplate) render(m slackio.Message) string {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, templateMessage{m, t.dir}); err != nil {
+		fmt.Fprintf(os.Stderr, "slackbridge: failed to render --%s: %v\n", t.tmpl.Name(), err)
+	}
+	return b.String()
+}
+
+// timestampTime converts a Slack message timestamp to a time.Time, returning
+// the zero Time if the timestamp is blank or invalid.
+func timestampTime(ts string) time.Time {
+	parts := strings.SplitN(ts, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	var usec int64
+	if len(parts) == 2 {
+		frac := (parts[1] + "000000")[:6]
+		usec, _ = strconv.ParseInt(frac, 10, 64)
+	}
+
+	return time.Unix(sec, usec*int64(time.Microsecond))
+}
+
+// childTemplateData is the data with which the command line, environment, and
+// working directory of mux child processes are executed as templates.
+type childTemplateData struct {
+	ChannelID   string
+	ChannelName string // falls back to ChannelID if it can't be resolved
+	ChannelType string // "public", "private", "im", "mpim", or blank if unknown
+	TeamID      string
+	UserID      string // the sender of the message that started the child
+	Slug        string // ChannelName, made safe for use in paths
+}
+
+// newChildTemplateData returns the template data for a child process started
+// by the given message, resolving channel names through dir (which may be
+// nil).
+func newChildTemplateData(msg slackio.Message, teamID string, dir *directory.Directory) childTemplateData {
+	tm := templateMessage{msg, dir}
+	data := childTemplateData{
+		ChannelID:   msg.ChannelID,
+		ChannelName: tm.Channel(),
+		ChannelType: tm.ChannelType(),
+		TeamID:      teamID,
+		UserID:      msg.UserID,
+	}
+	data.Slug = slugify(data.ChannelName)
+	if data.Slug == "" {
+		data.Slug = slugify(data.ChannelID)
+	}
+	return data
+}
+
+// slugify reduces s to lowercase letters, digits, '.', '_', and '-', replacing
+// runs of other characters with a single '-'. Leading and trailing '.' and '-'
+// are removed, so that the result can't name a parent or hidden directory.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+			dash = false
+		case !dash:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.Trim(b.String(), ".-")
+}
+
+// checkChildTemplates reports the first of the given templates that fails to
+// parse or refers to nonexistent fields, so that mistakes are caught before
+// any child process is started.
+func checkChildTemplates(texts ...string) error {
+	for _, text := range texts {
+		if _, err := executeChildTemplate(text, childTemplateData{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeChildTemplate parses and executes a single template for a child
+// process.
+func executeChildTemplate(text string, data childTemplateData) (string, error) {
+	tmpl, err := template.New("").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q: %v", text, err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("invalid template %q: %v", text, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the Slack user and team associated with the API token",
+	Long: `Whoami shows the user, team, and (for bot tokens) bot associated with the
+API token, as reported by Slack's auth.test API. It exits with status 3 if
+Slack rejects the token, which makes it a convenient way to check credentials.`,
+
+	Args: cobra.NoArgs,
+	Run:  runWhoamiCmd,
+}
+
+func init() {
+	RootCmd.AddCommand(whoamiCmd)
+	whoamiCmd.Flags().String("format", "table", `output format: "table" or "json"`)
+}
+
+func runWhoamiCmd(cmd *cobra.Command, args []string) {
+	apiToken := os.Getenv("SLACK_TOKEN")
+	if apiToken == "" {
+		fmt.Fprintln(os.Stderr, "Error: SLACK_TOKEN environment variable not set")
+		fmt.Fprintln(os.Stderr, RootCmd.UsageString())
+		os.Exit(1)
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	if format != "table" && format != "json" {
+		exitWithError(fmt.Errorf("unknown --format value %q", format))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clientSetupTimeout)
+	defer cancel()
+
+	self, err := slackio.AuthTest(ctx, apiToken)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(self); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "User:\t%s (%s)\n", self.User, self.UserID)
+	fmt.Fprintf(w, "Team:\t%s (%s)\n", self.Team, self.TeamID)
+	fmt.Fprintf(w, "URL:\t%s\n", self.URL)
+	if self.BotID != "" {
+		fmt.Fprintf(w, "Bot:\t%s\n", self.BotID)
+	}
+	if err := w.Flush(); err != nil {
+		exitWithError(err)
+	}
+}
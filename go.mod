@@ -3,9 +3,12 @@ module go.alexhamlin.co/slackbridge
 go 1.13
 
 require (
+	github.com/gorilla/websocket v1.4.0
 	github.com/hashicorp/go-multierror v1.0.0
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/nlopes/slack v0.5.0
+	github.com/pkg/errors v0.8.1 // indirect
 	github.com/spf13/cobra v0.0.0-20180531180338-1e58aa3361fd
 	github.com/spf13/pflag v1.0.3 // indirect
-	go.alexhamlin.co/slackio v0.2.1
+	gopkg.in/yaml.v2 v2.4.0
 )
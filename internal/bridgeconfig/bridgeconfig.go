@@ -0,0 +1,257 @@
+/*
+
+Package bridgeconfig loads the configuration of "slackbridge serve", which
+declares any number of bridges between Slack channels and local programs or
+files.
+
+Configuration files are written in YAML, or in JSON if their names end with
+".json". YAML files are decoded with the same field names and types as JSON
+files, so values that YAML reads as numbers or booleans, like 10 or true, must
+be quoted where strings are expected (e.g. in env).
+
+An example configuration:
+
+  bridges:
+    - name: deploy-bot
+      mode: exec
+      channel: C12345678
+      command: [./deploy-bot, --verbose]
+      env:
+        DEPLOY_ENV: production
+      dir: /srv/deploy-bot
+      restart: on-failure
+      restart_delay: 10s
+
+    - name: archive
+      mode: stream
+      channel: C87654321
+      output: /var/log/slack/archive.log
+
+*/
+package bridgeconfig // import "go.alexhamlin.co/slackbridge/internal/bridgeconfig"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bridge modes.
+const (
+	ModeExec   = "exec"   // a single child process connected to Channel
+	ModeMux    = "mux"    // a child process for each channel, as with mux
+	ModeStream = "stream" // messages appended to Output
+)
+
+// Restart policies for the child processes of exec and mux bridges.
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// DefaultRestartDelay is the delay before restarting a child process if a
+// bridge does not set one.
+const DefaultRestartDelay = 5 * time.Second
+
+// Config is the top level of a configuration file.
+type Config struct {
+	Bridges []Bridge `json:"bridges"`
+}
+
+// Bridge configures a single bridge.
+type Bridge struct {
+	// Name uniquely identifies the bridge, both in logs and when reloading the
+	// configuration.
+	Name string `json:"name"`
+
+	// Mode is one of ModeExec, ModeMux, or ModeStream.
+	Mode string `json:"mode"`
+
+	// Channel is the ID of the channel to connect to. It is required for exec
+	// bridges, and optional for mux bridges (which otherwise run a child process
+	// for every channel) and stream bridges (which otherwise stream every
+	// channel).
+	Channel string `json:"channel,omitempty"`
+
+	// Command is the command line of the child process of an exec or mux
+	// bridge. In mux bridges, each argument is a template as with mux.
+	Command []string `json:"command,omitempty"`
+
+	// Env adds to the environment of child processes. In mux bridges, each
+	// value is a template as with mux.
+	Env map[string]string `json:"env,omitempty"`
+
+	// Dir is the working directory of child processes. In mux bridges, it is a
+	// template as with mux.
+	Dir string `json:"dir,omitempty"`
+
+	// Output is the file to which a stream bridge appends messages, or "-" for
+	// slackbridge's stdout.
+	Output string `json:"output,omitempty"`
+
+	// Restart is the restart policy of an exec or mux bridge: RestartNever (the
+	// default), RestartOnFailure, or RestartAlways. Mux bridges restart a
+	// channel's child process on the next message from the channel, as with
+	// mux --respawn.
+	Restart string `json:"restart,omitempty"`
+
+	// RestartDelay is the time to wait before restarting a child process. In
+	// mux bridges, it only applies to children that crashed (see mux
+	// --crash-window).
+	RestartDelay Duration `json:"restart_delay,omitempty"`
+}
+
+// Duration is a time.Duration that is written in configuration files as a
+// string like "10s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be strings like \"10s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads and validates the configuration file at the given path.
+func Load(path string) (*Config, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parse(src, strings.HasSuffix(path, ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+func parse(src []byte, isJSON bool) (*Config, error) {
+	if !isJSON {
+		v, err := parseYAML(src)
+		if err != nil {
+			return nil, err
+		}
+		// Decoding the parsed document through JSON applies the same field
+		// names, types, and checks as JSON configuration files.
+		if src, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.DisallowUnknownFields()
+
+	var config Config
+	if err := dec.Decode(&config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	names := make(map[string]bool)
+	for i := range c.Bridges {
+		b := &c.Bridges[i]
+		if b.Name == "" {
+			return fmt.Errorf("bridge %d has no name", i+1)
+		}
+		if names[b.Name] {
+			return fmt.Errorf("duplicate bridge name %q", b.Name)
+		}
+		names[b.Name] = true
+
+		if err := b.validate(); err != nil {
+			return fmt.Errorf("bridge %q: %v", b.Name, err)
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) validate() error {
+	switch b.Mode {
+	case ModeExec:
+		if b.Channel == "" {
+			return fmt.Errorf("exec bridges require a channel")
+		}
+		fallthrough
+	case ModeMux:
+		if len(b.Command) == 0 {
+			return fmt.Errorf("%s bridges require a command", b.Mode)
+		}
+		if b.Output != "" {
+			return fmt.Errorf("output is only supported by stream bridges")
+		}
+	case ModeStream:
+		if b.Output == "" {
+			return fmt.Errorf("stream bridges require an output")
+		}
+		if len(b.Command) > 0 || len(b.Env) > 0 || b.Dir != "" {
+			return fmt.Errorf("command, env, and dir are not supported by stream bridges")
+		}
+	case "":
+		return fmt.Errorf("no mode given")
+	default:
+		return fmt.Errorf("unknown mode %q", b.Mode)
+	}
+
+	switch b.Restart {
+	case "":
+		b.Restart = RestartNever
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("unknown restart policy %q", b.Restart)
+	}
+	if b.Restart != RestartNever && b.Mode == ModeStream {
+		return fmt.Errorf("restart policies are not supported by stream bridges")
+	}
+	if b.RestartDelay == 0 {
+		b.RestartDelay = Duration(DefaultRestartDelay)
+	}
+	return nil
+}
+
+// Environ returns the environment of the bridge's child processes, which adds
+// Env to the given base environment (usually os.Environ()).
+func (b *Bridge) Environ(base []string) []string {
+	if len(b.Env) == 0 {
+		return base
+	}
+
+	keys := make([]string, 0, len(b.Env))
+	for k := range b.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(base)+len(keys))
+	for _, kv := range base {
+		if _, ok := b.Env[strings.SplitN(kv, "=", 2)[0]]; !ok {
+			env = append(env, kv)
+		}
+	}
+	for _, k := range keys {
+		env = append(env, k+"="+b.Env[k])
+	}
+	return env
+}
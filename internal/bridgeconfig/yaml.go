@@ -0,0 +1,51 @@
+package bridgeconfig
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// parseYAML parses a YAML document into the generic values produced by
+// encoding/json, so that it can be decoded like a JSON configuration file.
+// Duplicate mapping keys are rejected.
+func parseYAML(src []byte) (interface{}, error) {
+	var v interface{}
+	if err := yaml.UnmarshalStrict(src, &v); err != nil {
+		return nil, err
+	}
+	return jsonValue(v)
+}
+
+// jsonValue converts the mappings produced by the yaml package, whose keys may
+// be of any type, to mappings with string keys.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("mapping key %v is not a string", k)
+			}
+			var err error
+			if m[key], err = jsonValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if s[i], err = jsonValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+
+	default:
+		return v, nil
+	}
+}
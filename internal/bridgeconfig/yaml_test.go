@@ -0,0 +1,145 @@
+package bridgeconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAML(t *testing.T) {
+	testCases := []struct {
+		name string
+		yaml string
+		json string
+	}{
+		{"empty", "", `{}`},
+		{"no bridges", "bridges: []\n", `{"bridges": []}`},
+		{
+			name: "exec",
+			yaml: `
+bridges:
+  - name: deploy-bot  # a comment
+    mode: exec
+    channel: C12345678
+    command: [./deploy-bot, "--verbose"]
+    env:
+      DEPLOY_ENV: production
+      RETRIES: "3"
+    dir: /srv/deploy-bot
+    restart: on-failure
+    restart_delay: 10s
+`,
+			json: `{"bridges": [{
+				"name": "deploy-bot", "mode": "exec", "channel": "C12345678",
+				"command": ["./deploy-bot", "--verbose"],
+				"env": {"DEPLOY_ENV": "production", "RETRIES": "3"},
+				"dir": "/srv/deploy-bot", "restart": "on-failure", "restart_delay": "10s"
+			}]}`,
+		},
+		{
+			name: "block sequences and scalars",
+			yaml: `---
+bridges:
+- name: 'it''s a mux'
+  mode: mux
+  command:
+    - sh
+    - -c
+    - |
+      echo "{{.ChannelID}}"
+      exec cat
+- name: archive
+  mode: stream
+  output: "*.log"
+`,
+			json: `{"bridges": [
+				{"name": "it's a mux", "mode": "mux", "command": ["sh", "-c", "echo \"{{.ChannelID}}\"\nexec cat\n"]},
+				{"name": "archive", "mode": "stream", "output": "*.log"}
+			]}`,
+		},
+		{
+			name: "anchors and aliases",
+			yaml: `
+bridges:
+  - name: one
+    mode: exec
+    channel: C1
+    command: &cat [cat]
+  - name: two
+    mode: exec
+    channel: C2
+    command: *cat
+`,
+			json: `{"bridges": [
+				{"name": "one", "mode": "exec", "channel": "C1", "command": ["cat"]},
+				{"name": "two", "mode": "exec", "channel": "C2", "command": ["cat"]}
+			]}`,
+		},
+		{"null values", "bridges:\n  - name: a\n    mode: stream\n    channel: ~\n    output: '-'\n", `{"bridges": [{"name": "a", "mode": "stream", "output": "-"}]}`},
+	}
+
+	for _, tc := range testCases {
+		got, err := parse([]byte(tc.yaml), false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		want, err := parse([]byte(tc.json), true)
+		if err != nil {
+			t.Fatalf("%s: invalid JSON: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: parsed %+v; want %+v", tc.name, got, want)
+		}
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	config, err := parse([]byte("bridges:\n  - {name: a, mode: mux, command: [cat]}\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := config.Bridges[0]
+	if b.Restart != RestartNever || time.Duration(b.RestartDelay) != DefaultRestartDelay {
+		t.Errorf("restart = %q after %v; want %q after %v", b.Restart, time.Duration(b.RestartDelay), RestartNever, DefaultRestartDelay)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"tab indentation", "bridges:\n\t- name: a\n", "found character that cannot start any token"},
+		{"duplicate key", "bridges: []\nbridges: []\n", `key "bridges" already set`},
+		{"non-string key", "bridges:\n  - 1: a\n", "mapping key 1 is not a string"},
+		{"unknown field", "bridges:\n  - name: a\n    mode: stream\n    output: '-'\n    colour: red\n", `unknown field "colour"`},
+		{"number for a string", "bridges:\n  - name: a\n    mode: exec\n    channel: C1\n    command: [cat]\n    env: {RETRIES: 3}\n", "cannot unmarshal number"},
+		{"number for a duration", "bridges:\n  - name: a\n    mode: exec\n    channel: C1\n    command: [cat]\n    restart_delay: 10\n", "durations must be strings"},
+		{"invalid duration", "bridges:\n  - name: a\n    mode: exec\n    channel: C1\n    command: [cat]\n    restart_delay: soon\n", "invalid duration"},
+		{"no name", "bridges:\n  - mode: stream\n    output: '-'\n", "bridge 1 has no name"},
+		{"duplicate name", "bridges:\n  - {name: a, mode: stream, output: '-'}\n  - {name: a, mode: stream, output: '-'}\n", `duplicate bridge name "a"`},
+		{"no mode", "bridges:\n  - name: a\n", "no mode given"},
+		{"unknown mode", "bridges:\n  - {name: a, mode: pipe}\n", `unknown mode "pipe"`},
+		{"exec without channel", "bridges:\n  - {name: a, mode: exec, command: [cat]}\n", "exec bridges require a channel"},
+		{"mux without command", "bridges:\n  - {name: a, mode: mux}\n", "mux bridges require a command"},
+		{"mux with output", "bridges:\n  - {name: a, mode: mux, command: [cat], output: '-'}\n", "output is only supported by stream bridges"},
+		{"stream without output", "bridges:\n  - {name: a, mode: stream}\n", "stream bridges require an output"},
+		{"stream with command", "bridges:\n  - {name: a, mode: stream, output: '-', command: [cat]}\n", "not supported by stream bridges"},
+		{"stream with restart", "bridges:\n  - {name: a, mode: stream, output: '-', restart: always}\n", "restart policies are not supported by stream bridges"},
+		{"unknown restart", "bridges:\n  - {name: a, mode: mux, command: [cat], restart: sometimes}\n", `unknown restart policy "sometimes"`},
+	}
+
+	for _, tc := range testCases {
+		_, err := parse([]byte(tc.yaml), false)
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error = %q; want it to contain %q", tc.name, err, tc.err)
+		}
+	}
+}
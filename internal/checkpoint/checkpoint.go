@@ -0,0 +1,155 @@
+/*
+
+Package checkpoint persists the position of slackbridge within each Slack
+channel, so that the programs it bridges can resume where they left off after
+slackbridge restarts.
+
+A position is the Slack timestamp of the last message from a channel that was
+output by a Reader. Positions are stored in a directory, with one file per
+channel named after the channel's ID. Replaying a channel's history from its
+stored position (see slackio.Client.Replay) recovers the messages that were
+sent while slackbridge was not running.
+
+*/
+package checkpoint // import "go.alexhamlin.co/slackbridge/internal/checkpoint"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// validChannelID matches the channel IDs that are safe to use as file names
+// within a Store.
+var validChannelID = regexp.MustCompile(`^[A-Z0-9]+$`)
+
+// validTimestamp matches the Slack message timestamps that a Store saves.
+var validTimestamp = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+// Store persists channel positions in a directory.
+type Store struct {
+	dir string
+
+	mu    sync.Mutex
+	saved map[string]string
+}
+
+// Open returns a Store for the given directory, creating it if necessary.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("checkpoint: %v", err)
+	}
+
+	return &Store{
+		dir:   dir,
+		saved: make(map[string]string),
+	}, nil
+}
+
+// Load returns the stored position for the given channel, or a blank string if
+// no position has been stored. A stored position that is not a valid Slack
+// timestamp is reported as an error.
+func (s *Store) Load(channelID string) (string, error) {
+	if !validChannelID.MatchString(channelID) {
+		return "", fmt.Errorf("checkpoint: invalid channel ID %q", channelID)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, channelID))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("checkpoint: %v", err)
+	}
+
+	ts := strings.TrimSpace(string(data))
+	if !validTimestamp.MatchString(ts) {
+		return "", fmt.Errorf("checkpoint: invalid position %q for %s", ts, channelID)
+	}
+	return ts, nil
+}
+
+// All returns the stored positions for all channels, keyed by channel ID.
+func (s *Store) All() (map[string]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: %v", err)
+	}
+
+	positions := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !validChannelID.MatchString(entry.Name()) {
+			continue
+		}
+
+		ts, err := s.Load(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if ts != "" {
+			positions[entry.Name()] = ts
+		}
+	}
+
+	return positions, nil
+}
+
+// Save stores the position for the given channel. Positions only move forward:
+// if ts is not newer than the position most recently saved through this Store,
+// Save does nothing.
+func (s *Store) Save(channelID, ts string) error {
+	if !validChannelID.MatchString(channelID) {
+		return fmt.Errorf("checkpoint: invalid channel ID %q", channelID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.saved[channelID]; ok && !slackio.TimestampAfter(ts, last) {
+		return nil
+	}
+
+	// Writing to a temporary file and renaming it ensures that a crash can't
+	// leave a partially written position behind.
+	tmp, err := ioutil.TempFile(s.dir, "."+channelID+".")
+	if err != nil {
+		return fmt.Errorf("checkpoint: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(ts + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("checkpoint: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, channelID)); err != nil {
+		return fmt.Errorf("checkpoint: %v", err)
+	}
+
+	s.saved[channelID] = ts
+	return nil
+}
+
+// Recorder returns a function that saves the position of each message passed
+// to it, suitable for use as the OnOutput function of a slackio.Reader. A
+// Reader using a Recorder therefore records its progress through each channel,
+// counting only the messages that it actually output. Any error encountered
+// while saving a position is passed to errorHandler, if it is non-nil.
+func (s *Store) Recorder(errorHandler func(error)) func(slackio.Message) {
+	return func(msg slackio.Message) {
+		if msg.Timestamp == "" {
+			return
+		}
+		if err := s.Save(msg.ChannelID, msg.Timestamp); err != nil && errorHandler != nil {
+			errorHandler(err)
+		}
+	}
+}
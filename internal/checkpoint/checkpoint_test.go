@@ -0,0 +1,212 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+func TestSaveLoad(t *testing.T) {
+	store, dir := openTempStore(t)
+	defer os.RemoveAll(dir)
+
+	if ts, err := store.Load("C1"); ts != "" || err != nil {
+		t.Errorf("Load() before Save = (%q, %v); want blank", ts, err)
+	}
+
+	saves := []struct {
+		ts   string
+		want string
+	}{
+		{"1500000001.000100", "1500000001.000100"},
+		{"1500000002.000100", "1500000002.000100"},
+		{"1500000001.000200", "1500000002.000100"}, // positions only move forward
+		{"1500000002.000100", "1500000002.000100"},
+	}
+
+	for _, save := range saves {
+		if err := store.Save("C1", save.ts); err != nil {
+			t.Fatalf("Save(%q) = %v", save.ts, err)
+		}
+		if ts, err := store.Load("C1"); ts != save.want || err != nil {
+			t.Errorf("Load() after Save(%q) = (%q, %v); want %q", save.ts, ts, err, save.want)
+		}
+	}
+
+	// A new Store sees the saved position.
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := reopened.Load("C1"); ts != "1500000002.000100" || err != nil {
+		t.Errorf("Load() after reopening = (%q, %v); want %q", ts, err, "1500000002.000100")
+	}
+}
+
+func TestSaveLeavesNoTemporaryFiles(t *testing.T) {
+	store, dir := openTempStore(t)
+	defer os.RemoveAll(dir)
+
+	for _, ts := range []string{"1500000001.000100", "1500000002.000100"} {
+		if err := store.Save("C1", ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"C1"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("directory contains %q; want %q", names, want)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "C1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1500000002.000100\n"; string(data) != want {
+		t.Errorf("position file contains %q; want %q", data, want)
+	}
+}
+
+func TestAll(t *testing.T) {
+	store, dir := openTempStore(t)
+	defer os.RemoveAll(dir)
+
+	for channelID, ts := range map[string]string{"C1": "1500000001.000100", "D2": "1500000002.000100"} {
+		if err := store.Save(channelID, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files that a Store would never write, including the temporary files of an
+	// interrupted Save, are ignored.
+	writeFile(t, filepath.Join(dir, ".C3.12345"), "1500000003.0")
+	writeFile(t, filepath.Join(dir, "notes.txt"), "hello")
+	if err := os.Mkdir(filepath.Join(dir, "C4"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	positions, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"C1": "1500000001.000100", "D2": "1500000002.000100"}
+	if !reflect.DeepEqual(positions, want) {
+		t.Errorf("All() = %v; want %v", positions, want)
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+	}{
+		{"empty", ""},
+		{"partial", "1500000001."},
+		{"garbage", "\x00\x00\x00"},
+		{"not a timestamp", "yesterday\n"},
+	}
+
+	for _, tc := range testCases {
+		store, dir := openTempStore(t)
+		writeFile(t, filepath.Join(dir, "C1"), tc.contents)
+
+		if ts, err := store.Load("C1"); err == nil {
+			t.Errorf("%s: Load() = %q; want error", tc.name, ts)
+		}
+		if positions, err := store.All(); err == nil {
+			t.Errorf("%s: All() = %v; want error", tc.name, positions)
+		}
+
+		os.RemoveAll(dir)
+	}
+}
+
+func TestInvalidChannelID(t *testing.T) {
+	store, dir := openTempStore(t)
+	defer os.RemoveAll(dir)
+
+	for _, channelID := range []string{"", "../C1", ".C1", "c1"} {
+		if err := store.Save(channelID, "1500000001.000100"); err == nil {
+			t.Errorf("Save(%q) succeeded; want error", channelID)
+		}
+		if _, err := store.Load(channelID); err == nil {
+			t.Errorf("Load(%q) succeeded; want error", channelID)
+		}
+	}
+}
+
+func TestUnwritableDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A regular file can't contain a state directory, whatever our privileges.
+	file := filepath.Join(dir, "file")
+	writeFile(t, file, "")
+	if _, err := Open(filepath.Join(file, "state")); err == nil {
+		t.Error("Open() within a file succeeded; want error")
+	}
+
+	// Nor can a Store save positions once its directory is gone.
+	store, err := Open(filepath.Join(dir, "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "state")); err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []error
+	record := store.Recorder(func(err error) { errs = append(errs, err) })
+	record(slackio.Message{ChannelID: "C1", Timestamp: "1500000001.000100"})
+	if len(errs) != 1 {
+		t.Errorf("Recorder reported %d errors; want 1", len(errs))
+	}
+
+	// The failed position is not considered saved.
+	if err := os.Mkdir(filepath.Join(dir, "state"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("C1", "1500000001.000100"); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := store.Load("C1"); ts != "1500000001.000100" || err != nil {
+		t.Errorf("Load() = (%q, %v); want %q", ts, err, "1500000001.000100")
+	}
+}
+
+func openTempStore(t *testing.T) (*Store, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func writeFile(t *testing.T, name, contents string) {
+	t.Helper()
+	if err := ioutil.WriteFile(name, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -33,9 +33,21 @@ import (
 	multierror "github.com/hashicorp/go-multierror"
 )
 
+// Options customizes the environment in which a child process runs.
+type Options struct {
+	// Env, if non-nil, is the environment of the child process, with entries of
+	// the form "key=value". If nil, the child inherits slackbridge's environment.
+	Env []string
+
+	// Dir, if non-blank, is the working directory of the child process. If
+	// blank, the child inherits slackbridge's working directory.
+	Dir string
+}
+
 // Process is the type for a child process managed by package childproc.
 type Process struct {
 	process *os.Process
+	state   *os.ProcessState
 
 	readerCloser  io.Closer
 	childStdinOut *os.File
@@ -55,7 +67,15 @@ var numWaitErrors = 6
 // the provided ReadCloser and WriteCloser will be closed after it terminates.
 // Otherwise they will be left open.
 func Spawn(cmdline []string, inputReader io.ReadCloser, outputWriter io.WriteCloser) (proc *Process, err error) {
-	// Look up based on $PATH, just like package exec
+	return SpawnWithOptions(cmdline, inputReader, outputWriter, Options{})
+}
+
+// SpawnWithOptions is like Spawn, but uses the provided options to customize
+// the environment of the child process.
+func SpawnWithOptions(cmdline []string, inputReader io.ReadCloser, outputWriter io.WriteCloser, opts Options) (proc *Process, err error) {
+	// Look up based on $PATH, just like package exec. Note that a relative path
+	// containing a slash is resolved against slackbridge's own working
+	// directory, not opts.Dir.
 	path, err := exec.LookPath(cmdline[0])
 	if err != nil {
 		return nil, fmt.Errorf("childproc lookup failed: %v", err)
@@ -87,6 +107,8 @@ func Spawn(cmdline []string, inputReader io.ReadCloser, outputWriter io.WriteClo
 	}()
 
 	attrs := &os.ProcAttr{
+		Dir:   opts.Dir,
+		Env:   opts.Env,
 		Files: []*os.File{childStdinOut, childStdoutIn, childStdoutIn},
 	}
 	process, err := os.StartProcess(path, cmdline, attrs)
@@ -148,7 +170,8 @@ func (p *Process) Wait() error {
 	p.shutdownOnce.Do(func() {
 		var errs *multierror.Error
 
-		_, err := p.process.Wait()
+		state, err := p.process.Wait()
+		p.state = state
 		errs = multierror.Append(errs, err)
 
 		// A goroutine feeds the Reader's output to the child's stdin through a
@@ -177,3 +200,25 @@ func (p *Process) Wait() error {
 
 	return p.err
 }
+
+// Pid returns the operating system's identifier for the process.
+func (p *Process) Pid() int {
+	return p.process.Pid
+}
+
+// Signal sends a signal to the process. Signaling a process that has already
+// terminated returns an error.
+func (p *Process) Signal(sig os.Signal) error {
+	return p.process.Signal(sig)
+}
+
+// ExitCode waits for the process to terminate, as with Wait, and returns its
+// exit code. The exit code is -1 if the process was terminated by a signal, or
+// if it could not be waited on.
+func (p *Process) ExitCode() int {
+	p.Wait()
+	if p.state == nil {
+		return -1
+	}
+	return p.state.ExitCode()
+}
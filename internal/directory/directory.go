@@ -0,0 +1,205 @@
+/*
+
+Package directory resolves the IDs of Slack channels and users to their names,
+caching the results for the life of the process.
+
+Names are looked up through Slack's conversations.info and users.info Web API
+methods on first use. Failed lookups are retried only after a short interval,
+so that a transient failure does not hide a name forever, but an unknown ID
+does not cost an API call for every message. A direct message channel whose
+other user can't be looked up is named after the user's ID in the meantime.
+
+*/
+package directory // import "go.alexhamlin.co/slackbridge/internal/directory"
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// Channel types, as reported in Channel.Type.
+const (
+	TypePublic  = "public"
+	TypePrivate = "private"
+	TypeIM      = "im"
+	TypeMPIM    = "mpim"
+)
+
+// retryInterval is the minimum time between lookups of an ID that failed.
+const retryInterval = time.Minute
+
+// Channel describes a Slack conversation.
+type Channel struct {
+	ID string
+
+	// Name is the name of the channel without a leading "#". For direct
+	// messages, it is the name of the other user prefixed with "@".
+	Name string
+
+	// Type is one of TypePublic, TypePrivate, TypeIM, or TypeMPIM.
+	Type string
+}
+
+// User describes a Slack user.
+type User struct {
+	ID string
+
+	// Name is the user's display name, or their username if they have not set a
+	// display name.
+	Name string
+}
+
+// Directory resolves and caches Slack channel and user information. It is safe
+// for concurrent use.
+type Directory struct {
+	api *slack.Client
+
+	mu       sync.Mutex
+	channels map[string]Channel
+	users    map[string]User
+	failures map[string]failure // keyed by channel or user ID
+
+	// imUsers holds the IDs of the other users of direct message channels whose
+	// names could not be resolved, keyed by channel ID. Such channels are cached
+	// with the user ID in place of the name until the user can be looked up.
+	imUsers map[string]string
+}
+
+// failure records a failed lookup.
+type failure struct {
+	err  error
+	when time.Time
+}
+
+// New returns a Directory that looks up information using the given API client.
+func New(api *slack.Client) *Directory {
+	return &Directory{
+		api:      api,
+		channels: make(map[string]Channel),
+		users:    make(map[string]User),
+		failures: make(map[string]failure),
+		imUsers:  make(map[string]string),
+	}
+}
+
+// Channel returns information about the channel with the given ID. If the
+// other user of a direct message channel can't be looked up, the channel is
+// named after the user's ID instead (e.g. "@U12345678"), and the name is
+// resolved again on a later call.
+func (d *Directory) Channel(id string) (Channel, error) {
+	d.mu.Lock()
+	ch, ok := d.channels[id]
+	userID, unnamed := d.imUsers[id]
+	err := d.recentFailure(id)
+	d.mu.Unlock()
+	if ok {
+		if unnamed {
+			ch = d.nameIM(ch, userID)
+		}
+		return ch, nil
+	}
+	if err != nil {
+		return Channel{}, err
+	}
+
+	info, err := d.api.GetConversationInfo(id, false)
+	if err != nil {
+		return Channel{}, d.fail(id, fmt.Errorf("directory: looking up channel %s: %v", id, err))
+	}
+
+	ch = Channel{ID: id, Name: info.Name, Type: ChannelType(info)}
+	if ch.Type == TypeIM {
+		ch.Name = "@" + info.User
+		d.mu.Lock()
+		d.channels[id] = ch
+		d.imUsers[id] = info.User
+		d.mu.Unlock()
+		return d.nameIM(ch, info.User), nil
+	}
+
+	d.mu.Lock()
+	d.channels[id] = ch
+	d.mu.Unlock()
+	return ch, nil
+}
+
+// nameIM names a cached direct message channel after its other user, if the
+// user can be looked up. As User caches failures, this does not cost an API
+// call for every message when the user can't be looked up.
+func (d *Directory) nameIM(ch Channel, userID string) Channel {
+	user, err := d.User(userID)
+	if err != nil {
+		return ch
+	}
+
+	ch.Name = "@" + user.Name
+	d.mu.Lock()
+	d.channels[ch.ID] = ch
+	delete(d.imUsers, ch.ID)
+	d.mu.Unlock()
+	return ch
+}
+
+// User returns information about the user with the given ID.
+func (d *Directory) User(id string) (User, error) {
+	d.mu.Lock()
+	user, ok := d.users[id]
+	err := d.recentFailure(id)
+	d.mu.Unlock()
+	if ok {
+		return user, nil
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	info, err := d.api.GetUserInfo(id)
+	if err != nil {
+		return User{}, d.fail(id, fmt.Errorf("directory: looking up user %s: %v", id, err))
+	}
+
+	user = User{ID: id, Name: info.Profile.DisplayName}
+	if user.Name == "" {
+		user.Name = info.Name
+	}
+
+	d.mu.Lock()
+	d.users[id] = user
+	d.mu.Unlock()
+	return user, nil
+}
+
+// recentFailure returns the error from the last lookup of id if it failed within
+// retryInterval. d.mu must be held.
+func (d *Directory) recentFailure(id string) error {
+	if f, ok := d.failures[id]; ok && time.Since(f.when) < retryInterval {
+		return f.err
+	}
+	return nil
+}
+
+// fail records a failed lookup of id, and returns err.
+func (d *Directory) fail(id string, err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures[id] = failure{err: err, when: time.Now()}
+	return err
+}
+
+// ChannelType returns the type of a channel as reported by the Slack API, as
+// one of TypePublic, TypePrivate, TypeIM, or TypeMPIM.
+func ChannelType(ch *slack.Channel) string {
+	switch {
+	case ch.IsIM:
+		return TypeIM
+	case ch.IsMpIM:
+		return TypeMPIM
+	case ch.IsPrivate || ch.IsGroup:
+		return TypePrivate
+	default:
+		return TypePublic
+	}
+}
@@ -0,0 +1,76 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/nlopes/slack"
+
+	"go.alexhamlin.co/slackbridge/internal/slacktest"
+)
+
+func TestChannel(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+	slack.APIURL = server.URL
+
+	server.AddUser(slack.User{ID: "UALICE000", Name: "alice", Profile: slack.UserProfile{DisplayName: "Alice"}})
+	im := slack.Channel{}
+	im.ID, im.IsIM, im.User = "DALICE000", true, "UALICE000"
+	server.AddChannel(im)
+
+	d := New(slack.New("xoxb-test"))
+
+	testCases := []struct {
+		id   string
+		want Channel
+	}{
+		{"CGENERAL0", Channel{ID: "CGENERAL0", Name: "general", Type: TypePublic}},
+		{"DALICE000", Channel{ID: "DALICE000", Name: "@Alice", Type: TypeIM}},
+	}
+	for _, tc := range testCases {
+		ch, err := d.Channel(tc.id)
+		if err != nil {
+			t.Errorf("Channel(%q): unexpected error: %v", tc.id, err)
+			continue
+		}
+		if ch != tc.want {
+			t.Errorf("Channel(%q) = %+v; want %+v", tc.id, ch, tc.want)
+		}
+	}
+
+	if _, err := d.Channel("CMISSING0"); err == nil {
+		t.Errorf("Channel(%q): expected an error", "CMISSING0")
+	}
+}
+
+func TestChannelIMWithUnknownUser(t *testing.T) {
+	server := slacktest.NewServer()
+	defer server.Close()
+	slack.APIURL = server.URL
+
+	im := slack.Channel{}
+	im.ID, im.IsIM, im.User = "DBOB00000", true, "UBOB00000"
+	server.AddChannel(im)
+
+	d := New(slack.New("xoxb-test"))
+	want := Channel{ID: "DBOB00000", Name: "@UBOB00000", Type: TypeIM}
+
+	for i := 0; i < 2; i++ {
+		ch, err := d.Channel("DBOB00000")
+		if err != nil {
+			t.Fatalf("Channel: unexpected error: %v", err)
+		}
+		if ch != want {
+			t.Fatalf("Channel = %+v; want %+v", ch, want)
+		}
+	}
+
+	// The channel is cached even though its name is incomplete, so that
+	// conversations.info isn't called again for every message.
+	d.mu.Lock()
+	_, cached := d.channels["DBOB00000"]
+	d.mu.Unlock()
+	if !cached {
+		t.Error("channel with an unknown user was not cached")
+	}
+}
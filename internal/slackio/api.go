@@ -0,0 +1,160 @@
+package slackio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// apiHTTPClient is used for Web API requests that the Slack library does not
+// support. A timeout ensures that a hung request cannot prevent a Client from
+// closing.
+var apiHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// apiResponse contains the fields common to all Web API responses.
+type apiResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// Identity describes the user and team associated with an API token, as
+// reported by the auth.test Web API method. Unlike the Slack library's
+// equivalent, it includes the ID of the bot associated with a bot token.
+type Identity struct {
+	UserID string `json:"user_id"`
+	User   string `json:"user"`
+	TeamID string `json:"team_id"`
+	Team   string `json:"team"`
+	URL    string `json:"url"`
+
+	// BotID is blank for tokens that are not associated with a bot.
+	BotID string `json:"bot_id,omitempty"`
+}
+
+// AuthTest returns the identity associated with the given API token. If Slack
+// rejects the token, the error is ErrInvalidAuth.
+func AuthTest(ctx context.Context, apiToken string) (Identity, error) {
+	var self Identity
+	err := callAPIContext(ctx, "auth.test", apiToken, &self)
+	return self, err
+}
+
+// authErrors are the Web API error codes indicating that Slack rejected the
+// credentials used for a request.
+var authErrors = map[string]bool{
+	"invalid_auth":           true,
+	"not_authed":             true,
+	"account_inactive":       true,
+	"token_revoked":          true,
+	"token_expired":          true,
+	"not_allowed_token_type": true,
+}
+
+// IsAuthError reports whether err indicates that Slack rejected the API
+// credentials used for a request. This includes ErrInvalidAuth as well as the
+// corresponding errors returned by the underlying Slack library.
+func IsAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrInvalidAuth || authErrors[err.Error()]
+}
+
+// callAPI calls a Web API method that the Slack library does not fully
+// support, and decodes a successful response into v. As with the rest of the
+// Slack library, requests are sent relative to slack.APIURL, which allows a
+// local server to stand in for Slack.
+func callAPI(method, token string, v interface{}) error {
+	return callAPIContext(context.Background(), method, token, v)
+}
+
+// callAPIContext is like callAPI, but aborts the request when ctx is done.
+func callAPIContext(ctx context.Context, method, token string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, slack.APIURL+method, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := apiHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slackio: %s failed: %s", method, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var result apiResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+
+	if !result.OK {
+		if authErrors[result.Error] {
+			return ErrInvalidAuth
+		}
+		return fmt.Errorf("slackio: %s failed: %s", method, result.Error)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// Identity returns the identity associated with this Client's API token, as
+// reported by auth.test. The result of the first successful call is cached.
+func (c *Client) Identity() (Identity, error) {
+	return c.identityContext(context.Background())
+}
+
+// identityContext is like Identity, but aborts the auth.test request when ctx
+// is done.
+func (c *Client) identityContext(ctx context.Context) (Identity, error) {
+	if self, ok := c.cachedIdentity(); ok {
+		return self, nil
+	}
+
+	c.identityLock.Lock()
+	defer c.identityLock.Unlock()
+
+	if self, ok := c.cachedIdentity(); ok {
+		return self, nil
+	}
+
+	self, err := AuthTest(ctx, c.apiToken)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	c.self.Store(self)
+	return self, nil
+}
+
+// cachedIdentity returns the identity associated with this Client's API token
+// if it is already known, without making any requests.
+func (c *Client) cachedIdentity() (Identity, bool) {
+	self, ok := c.self.Load().(Identity)
+	return self, ok
+}
+
+// resolveIdentity looks up the Client's identity if it is not already known,
+// reporting any failure. Clients call it whenever they connect to Slack, so
+// that receive can recognize their own messages without making requests of its
+// own; a failed lookup is retried on the next connection.
+func (c *Client) resolveIdentity() {
+	if _, err := c.Identity(); err != nil {
+		c.reportError(err)
+	}
+}
@@ -0,0 +1,353 @@
+package slackio
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/nlopes/slack"
+)
+
+// backfillPageSize is the number of messages requested in each page of channel
+// history while backfilling.
+const backfillPageSize = 200
+
+// catchUp tracks a channel whose history is being fetched for a backfill or
+// replay, while its messages are held back from real-time distribution.
+type catchUp struct {
+	// pending holds the messages received from the channel in real time while
+	// its history is being fetched, to be distributed after the history.
+	pending []*slack.MessageEvent
+
+	// refetch is set if another backfill or replay of the channel is requested
+	// before the current one finishes, in which case history is fetched again
+	// from the channel's latest position.
+	refetch bool
+}
+
+// backfill distributes the messages that were sent while this Client was
+// disconnected from Slack. Specifically, for the channels from which the
+// Client has most recently distributed messages (see WithBackfill), it fetches
+// all newer messages through Slack's conversations.history API and distributes
+// them in order.
+//
+// backfill must be called from the goroutine that receives events from Slack,
+// before any event received over a new connection is distributed. History is
+// fetched in the background, and messages received in the meantime from the
+// channels being backfilled are held back until their histories have been
+// distributed; messages from other channels are distributed right away.
+// Backfilling is best-effort: if history can't be fetched for a channel,
+// messages from the gap are lost just as they would be without backfilling,
+// and the error is reported to the Client's error handler.
+func (c *Client) backfill() {
+	c.messagesLock.RLock()
+	positions := c.backfillPositions(time.Now())
+	c.messagesLock.RUnlock()
+
+	c.eventLock.Lock()
+	channelIDs := c.startCatchUp(positions)
+	c.eventLock.Unlock()
+	if len(channelIDs) == 0 {
+		return
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if err := c.catchUpChannels(channelIDs, positions); err != nil {
+			c.reportError(err)
+		}
+	}()
+}
+
+// backfillPositions returns the positions of the channels that should be
+// backfilled at the given time: the most recently active channels within the
+// limits set by WithBackfill. c.messagesLock must be held (at least for
+// reading).
+func (c *Client) backfillPositions(now time.Time) map[string]string {
+	var cutoff string
+	if c.backfillMaxAge > 0 {
+		t := now.Add(-c.backfillMaxAge)
+		cutoff = fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+	}
+
+	channelIDs := make([]string, 0, len(c.lastSeen))
+	for channelID, ts := range c.lastSeen {
+		if cutoff == "" || TimestampAfter(ts, cutoff) {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+
+	if c.backfillChannels >= 0 && len(channelIDs) > c.backfillChannels {
+		sort.Slice(channelIDs, func(i, j int) bool {
+			return TimestampAfter(c.lastSeen[channelIDs[i]], c.lastSeen[channelIDs[j]])
+		})
+		channelIDs = channelIDs[:c.backfillChannels]
+	}
+
+	positions := make(map[string]string, len(channelIDs))
+	for _, channelID := range channelIDs {
+		positions[channelID] = c.lastSeen[channelID]
+	}
+	return positions
+}
+
+// Replay distributes the messages from the main body of each of the given
+// channels that are newer than the corresponding Slack message timestamp, as
+// if they had just been received. Messages are fetched through Slack's
+// conversations.history API. This allows Readers to resume from a durable
+// position (e.g. the timestamp of the last message that a program processed
+// before it was restarted).
+//
+// Thread replies are only replayed if they were also sent to the channel, as
+// the history API omits all others.
+//
+// Each channel's replayed messages are distributed in order, before any
+// message from that channel that Slack sends after Replay is called; such
+// messages are held back until Replay is done with their channel. Messages from
+// other channels continue to be distributed while Replay runs. Subscribers
+// should be subscribed before calling Replay to receive the replayed messages.
+// If they have already received a message from a channel, that channel is only
+// replayed from that message onward, so that no message is received twice or
+// out of order. Note that as with any burst of incoming messages, subscribers
+// that fall behind the Client's bounded message queue will skip forward.
+//
+// If history can't be fetched for some channels, Replay continues with the
+// remaining channels and returns the errors for those that failed. If a channel
+// is already being backfilled, it is replayed by the backfill in the
+// background, and any error is reported to the Client's error handler instead.
+func (c *Client) Replay(positions map[string]string) error {
+	firstID := c.firstSubscribedID()
+
+	c.eventLock.Lock()
+
+	// Messages may already have been distributed from these channels before our
+	// subscribers arrived. Rewinding ensures that they are distributed again in
+	// order, but only as far as the latest message that a subscriber could have
+	// received. The channels are held back before eventLock is released, so that
+	// no real-time message can be distributed in between.
+	c.messagesLock.Lock()
+	from := make(map[string]string, len(positions))
+	for channelID, ts := range positions {
+		if id, ok := c.lastSeenID[channelID]; ok && id >= firstID && !TimestampAfter(ts, c.lastSeen[channelID]) {
+			ts = c.lastSeen[channelID]
+		}
+		c.lastSeen[channelID] = ts
+		from[channelID] = ts
+	}
+	c.messagesLock.Unlock()
+
+	channelIDs := c.startCatchUp(from)
+	c.eventLock.Unlock()
+
+	return c.catchUpChannels(channelIDs, from)
+}
+
+// firstSubscribedID returns the ID at which the earliest of the Client's
+// current subscriptions started, or the ID of the next message if it has none.
+func (c *Client) firstSubscribedID() int {
+	c.messagesLock.RLock()
+	firstID := c.nextMessageID
+	c.messagesLock.RUnlock()
+
+	c.subsLock.Lock()
+	defer c.subsLock.Unlock()
+
+	for _, sub := range c.subs {
+		if sub.start < firstID {
+			firstID = sub.start
+		}
+	}
+	return firstID
+}
+
+// startCatchUp begins holding back real-time messages from the given channels
+// while their histories are fetched, and returns the IDs of the channels whose
+// histories the caller must now fetch using catchUpChannels. Channels that are
+// already being caught up are refetched by their existing catch-up instead.
+// c.eventLock must be held.
+func (c *Client) startCatchUp(positions map[string]string) []string {
+	var channelIDs []string
+	for channelID := range positions {
+		if cu, ok := c.catchingUp[channelID]; ok {
+			cu.refetch = true
+			continue
+		}
+		c.catchingUp[channelID] = &catchUp{}
+		channelIDs = append(channelIDs, channelID)
+	}
+	return channelIDs
+}
+
+// catchUpChannels fetches and distributes the histories of channels passed to
+// startCatchUp, each followed by the messages held back from it in the
+// meantime, and returns any errors encountered.
+func (c *Client) catchUpChannels(channelIDs []string, positions map[string]string) error {
+	var errs *multierror.Error
+
+	// Unlike in real time, history includes the messages that this Client's
+	// user sent through the Web API (possibly during a previous run), which we
+	// must skip to avoid echoing them back to Readers.
+	self, err := c.Identity()
+	if err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	// If the Client is closed, or we don't know which messages to skip, the
+	// remaining channels are released without fetching their histories.
+	release := err != nil
+	for _, channelID := range channelIDs {
+		select {
+		case <-c.done:
+			release = true
+		default:
+		}
+
+		if release {
+			c.finishCatchUp(channelID, false)
+			continue
+		}
+
+		if err := c.catchUpChannel(channelID, positions[channelID], self); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// catchUpChannel fetches and distributes the history of a single channel since
+// the given timestamp, followed by the messages held back from it.
+func (c *Client) catchUpChannel(channelID, ts string, self Identity) error {
+	for {
+		msgs, err := c.historySince(channelID, ts)
+		if err != nil {
+			c.finishCatchUp(channelID, false)
+			return err
+		}
+
+		// The channel's real-time messages are held back until the catch-up
+		// finishes, so its history is distributed without holding c.eventLock.
+		// Otherwise, other channels would be held up for as long as blocking
+		// subscribers take to make room for the history.
+		for _, hm := range ChronologicalHistory(msgs) {
+			m := slack.MessageEvent(hm)
+			m.Channel = channelID
+
+			if isSelf(self, &m) {
+				continue
+			}
+			c.distribute(&m)
+		}
+
+		var refetch bool
+		if ts, refetch = c.finishCatchUp(channelID, true); !refetch {
+			return nil
+		}
+	}
+}
+
+// finishCatchUp distributes the messages held back from a channel that was
+// being caught up, and resumes real-time distribution for it. Messages that
+// arrive while the held-back messages are distributed are held back in turn.
+//
+// If allowRefetch is set and another catch-up of the channel was requested in
+// the meantime, finishCatchUp instead returns true along with the timestamp
+// from which the channel's history must be fetched again, and the channel
+// remains held back.
+func (c *Client) finishCatchUp(channelID string, allowRefetch bool) (ts string, refetch bool) {
+	for {
+		c.eventLock.Lock()
+		cu := c.catchingUp[channelID]
+		if allowRefetch && cu.refetch {
+			cu.refetch = false
+			c.messagesLock.RLock()
+			ts = c.lastSeen[channelID]
+			c.messagesLock.RUnlock()
+			c.eventLock.Unlock()
+			return ts, true
+		}
+
+		pending := cu.pending
+		cu.pending = nil
+		if len(pending) == 0 {
+			delete(c.catchingUp, channelID)
+			c.eventLock.Unlock()
+			return "", false
+		}
+		c.eventLock.Unlock()
+
+		for _, m := range pending {
+			c.distribute(m)
+		}
+	}
+}
+
+// historySince returns all messages in the main body of a channel that are
+// newer than the given timestamp, ordered from newest to oldest.
+func (c *Client) historySince(channelID, ts string) ([]slack.Message, error) {
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Oldest:    ts,
+		Limit:     backfillPageSize,
+	}
+
+	var msgs []slack.Message
+	for {
+		resp, err := c.api.GetConversationHistory(params)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, resp.Messages...)
+		if !resp.HasMore || resp.ResponseMetaData.NextCursor == "" {
+			return msgs, nil
+		}
+		params.Cursor = resp.ResponseMetaData.NextCursor
+	}
+}
+
+// ChronologicalHistory reorders messages returned by Slack's
+// conversations.history API, which are ordered from newest to oldest, to be
+// ordered from oldest to newest as if they had been received in real time. The
+// slice is reordered in place and returned.
+//
+// The parent message of a thread carries its own timestamp as its thread
+// timestamp in history, but is part of the main body of the channel. To match
+// the messages received in real time, ChronologicalHistory clears the thread
+// timestamp of such messages.
+func ChronologicalHistory(msgs []slack.Message) []slack.Message {
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	for i := range msgs {
+		if msgs[i].ThreadTimestamp == msgs[i].Timestamp {
+			msgs[i].ThreadTimestamp = ""
+		}
+	}
+	return msgs
+}
+
+// TimestampAfter reports whether Slack message timestamp a is strictly newer
+// than b. Slack timestamps are decimal strings of seconds and microseconds
+// (e.g. "1500000000.000100").
+func TimestampAfter(a, b string) bool {
+	aSec, aFrac := splitTimestamp(a)
+	bSec, bFrac := splitTimestamp(b)
+	if aSec != bSec {
+		return aSec > bSec
+	}
+	return aFrac > bFrac
+}
+
+func splitTimestamp(ts string) (sec, frac int64) {
+	parts := strings.SplitN(ts, ".", 2)
+	sec, _ = strconv.ParseInt(parts[0], 10, 64)
+	if len(parts) == 2 {
+		frac, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	return
+}
@@ -0,0 +1,183 @@
+package slackio
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestBackfillPositions(t *testing.T) {
+	now := time.Unix(1500100000, 0)
+
+	testCases := []struct {
+		channels int
+		maxAge   time.Duration
+		want     map[string]string
+	}{
+		{-1, 0, map[string]string{"C1": "1500000000.000100", "C2": "1500099000.000100", "C3": "1500099900.000100"}},
+		{-1, time.Hour, map[string]string{"C2": "1500099000.000100", "C3": "1500099900.000100"}},
+		{1, 0, map[string]string{"C3": "1500099900.000100"}},
+		{0, 0, map[string]string{}},
+	}
+
+	for _, tc := range testCases {
+		client := initClient(WithBackfill(tc.channels, tc.maxAge))
+		client.lastSeen = map[string]string{
+			"C1": "1500000000.000100",
+			"C2": "1500099000.000100",
+			"C3": "1500099900.000100",
+		}
+
+		if got := client.backfillPositions(now); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("WithBackfill(%d, %v): backfillPositions() = %v; want %v", tc.channels, tc.maxAge, got, tc.want)
+		}
+	}
+}
+
+func TestCatchUpHoldsBackChannel(t *testing.T) {
+	client := initClient()
+	client.self.Store(Identity{UserID: "UBOT", BotID: "BBOT"})
+	defer close(client.done)
+
+	ch := make(chan Message, 10)
+	if err := client.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	client.startCatchUp(map[string]string{"C1": "1500000000.000100"})
+
+	for _, m := range []struct{ channelID, ts string }{
+		{"C1", "1500000001.000100"},
+		{"C2", "1500000002.000100"},
+	} {
+		client.receive(&slack.MessageEvent{Msg: slack.Msg{
+			Type:      "message",
+			Channel:   m.channelID,
+			Timestamp: m.ts,
+			Text:      "hello",
+		}})
+	}
+
+	if msg := receiveMessage(t, ch); msg.ChannelID != "C2" {
+		t.Errorf("received message from %s before catch-up finished; want C2", msg.ChannelID)
+	}
+
+	client.finishCatchUp("C1", false)
+
+	if msg := receiveMessage(t, ch); msg.ChannelID != "C1" {
+		t.Errorf("received message from %s after catch-up finished; want C1", msg.ChannelID)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	history := []slack.Msg{
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1500000001.000100", Text: "before"},
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1500000002.000100", Text: "live"},
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1500000003.000100", Text: "missed"},
+	}
+
+	testCases := []struct {
+		name   string
+		before []slack.Msg // received before subscribing
+		after  []slack.Msg // received after subscribing, before Replay
+		want   []string
+	}{
+		{
+			name: "nothing received",
+			want: []string{"before", "live", "missed", "done"},
+		},
+		{
+			name:   "received before subscribing",
+			before: history[:1],
+			want:   []string{"before", "live", "missed", "done"},
+		},
+		{
+			name:   "received after subscribing",
+			before: history[:1],
+			after:  history[1:2],
+			want:   []string{"live", "missed", "done"},
+		},
+	}
+
+	server := httptest.NewServer(historyHandler(history))
+	defer server.Close()
+
+	apiURL := slack.APIURL
+	slack.APIURL = server.URL + "/"
+	defer func() { slack.APIURL = apiURL }()
+
+	for _, tc := range testCases {
+		client := initClient()
+		client.api = slack.New("xoxb-test")
+		client.self.Store(Identity{UserID: "UBOT", BotID: "BBOT"})
+
+		for _, m := range tc.before {
+			client.receive(&slack.MessageEvent{Msg: m})
+		}
+
+		ch := make(chan Message, 10)
+		if err := client.Subscribe(ch); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, m := range tc.after {
+			client.receive(&slack.MessageEvent{Msg: m})
+		}
+
+		if err := client.Replay(map[string]string{"C1": "1500000000.000100"}); err != nil {
+			t.Errorf("%s: Replay() = %v", tc.name, err)
+		}
+		client.receive(&slack.MessageEvent{Msg: slack.Msg{Type: "message", Channel: "C1", User: "U1", Timestamp: "1500000004.000100", Text: "done"}})
+
+		var got []string
+		for len(got) == 0 || got[len(got)-1] != "done" {
+			got = append(got, receiveMessage(t, ch).Text)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: received %q; want %q", tc.name, got, tc.want)
+		}
+
+		client.Unsubscribe(ch)
+		close(client.done)
+	}
+}
+
+// historyHandler serves Slack's conversations.history Web API method with the
+// messages from msgs (ordered from oldest to newest) that are newer than the
+// requested timestamp.
+func historyHandler(msgs []slack.Msg) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		oldest := r.FormValue("oldest")
+
+		var resp struct {
+			OK       bool        `json:"ok"`
+			Messages []slack.Msg `json:"messages"`
+		}
+		resp.OK = true
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if TimestampAfter(msgs[i].Timestamp, oldest) {
+				resp.Messages = append(resp.Messages, msgs[i])
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func receiveMessage(t *testing.T, ch <-chan Message) Message {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return Message{}
+	}
+}
@@ -0,0 +1,112 @@
+package slackio
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineBatcher(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"one\n", []string{"one"}},
+		{"one\ntwo\nthree\n", []string{"one", "two", "three"}},
+		{"one\n\nthree", []string{"one", "", "three"}},
+	}
+
+	for _, tc := range testCases {
+		outCh, errCh := LineBatcher(strings.NewReader(tc.input))
+
+		var got []string
+		for s := range outCh {
+			got = append(got, s)
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("LineBatcher(%q) = %q; want %q", tc.input, got, tc.want)
+		}
+		if err := <-errCh; err != nil {
+			t.Errorf("LineBatcher(%q): unexpected error: %v", tc.input, err)
+		}
+	}
+}
+
+func TestIntervalBatcher(t *testing.T) {
+	timer := make(chan time.Time)
+	var interval time.Duration
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		interval = d
+		return timer
+	}
+	defer func() { timeAfter = time.After }()
+
+	// Sends on an unbuffered channel complete only once the batcher receives
+	// them, which lets us control exactly what falls within each interval.
+	upstreamCh, upstreamErrCh := make(chan string), make(chan error, 1)
+	upstream := func(io.Reader) (<-chan string, <-chan error) {
+		return upstreamCh, upstreamErrCh
+	}
+
+	outCh, errCh := NewIntervalBatcher(upstream, time.Second, "\n")(nil)
+
+	upstreamCh <- "one"
+	upstreamCh <- "two"
+	if interval != time.Second {
+		t.Errorf("timer started for %v; want %v", interval, time.Second)
+	}
+
+	timer <- time.Now()
+	if got := <-outCh; got != "one\ntwo" {
+		t.Errorf("first batch = %q; want %q", got, "one\ntwo")
+	}
+
+	// When the upstream batcher terminates, any remaining output is flushed
+	// without waiting for the timer.
+	upstreamCh <- "three"
+	upstreamErrCh <- io.ErrUnexpectedEOF
+	close(upstreamCh)
+
+	if got := <-outCh; got != "three" {
+		t.Errorf("second batch = %q; want %q", got, "three")
+	}
+	if s, ok := <-outCh; ok {
+		t.Errorf("unexpected batch %q after upstream terminated", s)
+	}
+	if err := <-errCh; err != io.ErrUnexpectedEOF {
+		t.Errorf("error = %v; want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestIntervalBatcherSkipsEmptyFlush(t *testing.T) {
+	timer := make(chan time.Time)
+	timeAfter = func(time.Duration) <-chan time.Time { return timer }
+	defer func() { timeAfter = time.After }()
+
+	upstreamCh, upstreamErrCh := make(chan string), make(chan error, 1)
+	upstream := func(io.Reader) (<-chan string, <-chan error) {
+		return upstreamCh, upstreamErrCh
+	}
+
+	outCh, errCh := NewIntervalBatcher(upstream, time.Second, "\n")(nil)
+
+	upstreamCh <- "one"
+	timer <- time.Now()
+	if got := <-outCh; got != "one" {
+		t.Errorf("batch = %q; want %q", got, "one")
+	}
+
+	upstreamErrCh <- nil
+	close(upstreamCh)
+
+	if s, ok := <-outCh; ok {
+		t.Errorf("unexpected batch %q with no new output", s)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,623 @@
+package slackio
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// ErrAlreadySubscribed is returned when an attempt is made to subscribe a
+// channel that already has a subscription.
+var ErrAlreadySubscribed = errors.New("slackio: channel already subscribed")
+
+// ErrNotSubscribed is returned when an attempt is made to unsubscribe a
+// channel that is not currently subscribed.
+var ErrNotSubscribed = errors.New("slackio: channel not subscribed")
+
+// ErrInvalidAuth is returned when Slack rejects a Client's API credentials,
+// either at construction or when the Client fails after they are revoked.
+var ErrInvalidAuth = errors.New("slackio: Slack API credentials are invalid")
+
+// Client implements an ability to send and receive Slack messages using a
+// real-time API. For readers, it presents a long-running stream of a user's
+// incoming Slack messages that may be consumed using multiple independent
+// channels. For writers, it allows instant sending of a message to a given
+// channel.
+//
+// A Client instance encapsulates a WebSocket connection to Slack, established
+// using either the legacy real-time messaging (RTM) API or Socket Mode. Users
+// of slackio should create a single Client and share it across Reader and
+// Writer instances.
+type Client struct {
+	api        *slack.Client
+	apiToken   string
+	send       func(Message)
+	disconnect func() error
+
+	self         atomic.Value // Identity, once known
+	identityLock sync.Mutex   // serializes lookups of self
+
+	wg   sync.WaitGroup
+	done chan struct{}
+
+	errorHandler func(error)
+	failed       chan struct{}
+	failOnce     sync.Once
+	err          error
+
+	// eventLock guards catchingUp, which holds the channels whose histories are
+	// being fetched, and is held while distributing messages received in real
+	// time. Messages from a channel being caught up are held back instead, so
+	// that each channel's messages are always distributed in order.
+	eventLock  sync.Mutex
+	catchingUp map[string]*catchUp
+
+	backfillChannels int
+	backfillMaxAge   time.Duration
+
+	queueSize     int
+	retention     time.Duration
+	messages      []queuedMessage
+	messagesLock  sync.RWMutex
+	messagesCond  *sync.Cond
+	nextMessageID int
+
+	// lastSeen holds the timestamp of the latest message distributed from each
+	// channel, and lastSeenID holds that message's ID.
+	lastSeen   map[string]string
+	lastSeenID map[string]int
+
+	// evicted holds the channel IDs of the messages most recently removed from
+	// the queue, in order, starting with the message whose ID is evictedStart.
+	// It allows subscriptions that skip forward to report which channels they
+	// lost messages from.
+	evicted      []string
+	evictedStart int
+
+	// consumed is closed and replaced whenever a subscription receives a
+	// message, to wake distributors waiting on subscriptions that use
+	// OverflowBlock.
+	consumed     chan struct{}
+	consumedLock sync.Mutex
+
+	ignored     map[string]bool
+	ignoredList []string
+	ignoredLock sync.Mutex
+
+	subs     map[chan<- Message]*subscription
+	subsLock sync.Mutex
+}
+
+// queuedMessage is a Message in a Client's message queue.
+type queuedMessage struct {
+	Message
+	received time.Time
+}
+
+// NewClient returns a new Client and connects it to Slack using the given API
+// token. NewClient does not verify the token; if Slack rejects it, the Client
+// fails with ErrInvalidAuth (see Failed and Err). Use NewClientContext to
+// verify the token before the Client is returned.
+func NewClient(apiToken string, opts ...ClientOption) *Client {
+	if apiToken == "" {
+		panic("slackio: Client requires a non-blank API token")
+	}
+
+	c := initClient(opts...)
+	c.connectRTM(apiToken)
+	return c
+}
+
+// NewClientContext is like NewClient, but first verifies the API token using
+// Slack's auth.test Web API method, returning ErrInvalidAuth if Slack rejects
+// it. The context bounds the verification only, not the life of the Client.
+func NewClientContext(ctx context.Context, apiToken string, opts ...ClientOption) (*Client, error) {
+	if apiToken == "" {
+		return nil, errors.New("slackio: Client requires a non-blank API token")
+	}
+
+	c := initClient(opts...)
+	c.apiToken = apiToken
+	if _, err := c.identityContext(ctx); err != nil {
+		return nil, err
+	}
+
+	c.connectRTM(apiToken)
+	return c, nil
+}
+
+// connectRTM connects the Client to Slack's real-time messaging API and starts
+// processing its events.
+func (c *Client) connectRTM(apiToken string) {
+	c.api, c.apiToken = slack.New(apiToken), apiToken
+	rtm := c.api.NewRTM()
+	go rtm.ManageConnection()
+
+	c.send = func(m Message) {
+		rtm.SendMessage(rtm.NewOutgoingMessage(m.Text, m.ChannelID))
+	}
+	c.disconnect = rtm.Disconnect
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			select {
+			case evt := <-rtm.IncomingEvents:
+				switch data := evt.Data.(type) {
+				case *slack.InvalidAuthEvent:
+					// The RTM library gives up on the connection at this point, so
+					// the Client will never receive another message.
+					c.fail(ErrInvalidAuth)
+
+				case *slack.ConnectionErrorEvent:
+					c.reportError(data.ErrorObj)
+
+				case *slack.ConnectedEvent:
+					// backfill holds back messages from the channels it backfills
+					// before returning, so events that arrive over the new connection
+					// are distributed after any backfilled messages.
+					c.resolveIdentity()
+					c.backfill()
+
+				case *slack.AckMessage:
+					c.IgnoreTimestamp(data.Timestamp)
+
+				case *slack.MessageEvent:
+					c.receive(data)
+				}
+
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// initClient returns a Client with basic fields initialized and the given
+// options applied. It mainly helps remove a bit of boilerplate from tests.
+func initClient(opts ...ClientOption) *Client {
+	c := &Client{
+		queueSize:        DefaultQueueSize,
+		backfillChannels: DefaultBackfillChannels,
+		backfillMaxAge:   DefaultBackfillMaxAge,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.done = make(chan struct{})
+	c.failed = make(chan struct{})
+	c.consumed = make(chan struct{})
+	c.messagesCond = sync.NewCond(c.messagesLock.RLocker())
+	c.subs = make(map[chan<- Message]*subscription)
+	c.lastSeen = make(map[string]string)
+	c.lastSeenID = make(map[string]int)
+	c.catchingUp = make(map[string]*catchUp)
+	c.ignored = make(map[string]bool)
+
+	return c
+}
+
+// Failed returns a channel that is closed if this Client permanently stops
+// receiving messages due to an unrecoverable error, such as Slack rejecting
+// its API credentials after they are revoked. Err returns the error once the
+// channel is closed.
+func (c *Client) Failed() <-chan struct{} {
+	return c.failed
+}
+
+// Err returns the unrecoverable error that caused this Client to fail, or nil
+// if it has not failed.
+func (c *Client) Err() error {
+	select {
+	case <-c.failed:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// fail records an unrecoverable error and closes the Failed channel. Only the
+// first error is recorded.
+func (c *Client) fail(err error) {
+	c.failOnce.Do(func() {
+		c.err = err
+		close(c.failed)
+	})
+}
+
+// reportError passes a recoverable error, such as a failed connection attempt
+// that will be retried, to the Client's error handler (see WithErrorHandler).
+// Authentication errors are unrecoverable, and cause the Client to fail.
+func (c *Client) reportError(err error) {
+	if IsAuthError(err) {
+		c.fail(ErrInvalidAuth)
+		return
+	}
+
+	if c.errorHandler != nil {
+		c.errorHandler(err)
+	}
+}
+
+// receive distributes a message received from Slack in real time.
+func (c *Client) receive(m *slack.MessageEvent) {
+	// Socket Mode and the Events API deliver the messages that this Client's
+	// user sends through the Web API, as does RTM if they were not sent over the
+	// RTM connection itself. The Client can't always ignore them by timestamp in
+	// time, as they may arrive before the response to the request that sent
+	// them. The identity is looked up when the Client connects, rather than
+	// here, so that a slow or failing lookup can't hold up every message.
+	if self, ok := c.cachedIdentity(); ok && isSelf(self, m) {
+		return
+	}
+
+	c.eventLock.Lock()
+	defer c.eventLock.Unlock()
+
+	if cu, ok := c.catchingUp[m.Channel]; ok {
+		cu.pending = append(cu.pending, m)
+		return
+	}
+	c.distribute(m)
+}
+
+// isSelf reports whether m was sent by the bot with the given identity. Messages
+// from users other than bots are never considered to be from the Client itself,
+// as the user may also write messages outside of slackbridge.
+func isSelf(self Identity, m *slack.MessageEvent) bool {
+	return self.BotID != "" && (m.BotID == self.BotID || m.User == self.UserID)
+}
+
+// distribute pushes non-empty messages from a Slack channel (including thread
+// replies) onto the queue for subscriber distribution. Messages sent by this
+// Client, and messages that are not newer than the latest message already
+// distributed from the same channel, are skipped.
+func (c *Client) distribute(m *slack.MessageEvent) {
+	if m.Type != "message" ||
+		m.ReplyTo > 0 ||
+		m.Text == "" ||
+		c.isIgnored(m.Timestamp) {
+		return
+	}
+
+	c.waitForBlockingSubscribers()
+
+	c.messagesLock.Lock()
+	defer c.messagesLock.Unlock()
+
+	if m.Timestamp != "" {
+		if last, ok := c.lastSeen[m.Channel]; ok && !TimestampAfter(m.Timestamp, last) {
+			return
+		}
+		c.lastSeen[m.Channel] = m.Timestamp
+		c.lastSeenID[m.Channel] = c.nextMessageID
+	}
+
+	userID := m.User
+	if userID == "" {
+		userID = m.BotID
+	}
+
+	now := time.Now()
+	n := c.overflow(now)
+	c.recordEvicted(c.messages[:n])
+	c.messages = append(c.messages[n:], queuedMessage{
+		Message: Message{
+			ID:              c.nextMessageID,
+			ChannelID:       m.Channel,
+			Text:            m.Text,
+			Timestamp:       m.Timestamp,
+			UserID:          userID,
+			ThreadTimestamp: m.ThreadTimestamp,
+			Broadcast:       m.SubType == "thread_broadcast",
+		},
+		received: now,
+	})
+
+	c.nextMessageID++
+	c.messagesCond.Broadcast()
+}
+
+// overflow returns the number of messages that must be removed from the front
+// of the queue to make room for a new message received at the given time.
+// c.messagesLock must be held.
+func (c *Client) overflow(now time.Time) int {
+	var n int
+	if c.queueSize > 0 && len(c.messages) >= c.queueSize {
+		n = len(c.messages) - c.queueSize + 1
+	}
+
+	if c.retention > 0 {
+		for n < len(c.messages) && now.Sub(c.messages[n].received) > c.retention {
+			n++
+		}
+	}
+
+	return n
+}
+
+// maxEvicted is the number of messages removed from a Client's queue whose
+// channel IDs are kept for reporting lost messages.
+const maxEvicted = 10000
+
+// recordEvicted records the channels of messages removed from the front of the
+// queue. c.messagesLock must be held.
+func (c *Client) recordEvicted(msgs []queuedMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	if len(c.evicted) == 0 {
+		c.evictedStart = msgs[0].ID
+	}
+	for _, qm := range msgs {
+		c.evicted = append(c.evicted, qm.ChannelID)
+	}
+
+	if extra := len(c.evicted) - maxEvicted; extra > 0 {
+		c.evicted = c.evicted[extra:]
+		c.evictedStart += extra
+	}
+}
+
+// lostMessages returns the number of messages removed from the queue with IDs
+// from start up to (but not including) end, keyed by channel ID. Messages
+// removed so long ago that their channels are no longer known are counted
+// under the empty string. c.messagesLock must be held (at least for reading).
+func (c *Client) lostMessages(start, end int) map[string]int {
+	lost := make(map[string]int)
+
+	if unknown := minInt(end, c.evictedStart) - start; unknown > 0 {
+		lost[""] = unknown
+		start += unknown
+	}
+	for id := start; id < end && id-c.evictedStart < len(c.evicted); id++ {
+		lost[c.evicted[id-c.evictedStart]]++
+	}
+
+	return lost
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// waitForBlockingSubscribers delays the distribution of a new message while
+// doing so would remove a message from the queue that a subscription using
+// OverflowBlock has yet to receive, up to the MaxBlock of that subscription.
+func (c *Client) waitForBlockingSubscribers() {
+	var deadline <-chan time.Time
+
+	for {
+		// We need to grab this before checking on our subscribers, so that we
+		// can't miss a notification that arrives in between.
+		c.consumedLock.Lock()
+		consumed := c.consumed
+		c.consumedLock.Unlock()
+
+		maxBlock, blocked := c.blockingSubscriberBehind()
+		if !blocked {
+			return
+		}
+
+		if deadline == nil {
+			timer := time.NewTimer(maxBlock)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		select {
+		case <-consumed:
+		case <-deadline:
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// blockingSubscriberBehind reports whether a subscription using OverflowBlock
+// would lose a message if a new message were distributed now. If so, it also
+// returns the shortest MaxBlock of all such subscriptions.
+func (c *Client) blockingSubscriberBehind() (maxBlock time.Duration, blocked bool) {
+	c.messagesLock.RLock()
+	n := c.overflow(time.Now())
+	var limit int
+	if n > 0 {
+		limit = c.messages[n-1].ID + 1
+	}
+	c.messagesLock.RUnlock()
+
+	if n == 0 {
+		return 0, false
+	}
+
+	c.subsLock.Lock()
+	defer c.subsLock.Unlock()
+
+	for _, sub := range c.subs {
+		if sub.opts.Overflow != OverflowBlock || sub.position() >= limit {
+			continue
+		}
+
+		if subMaxBlock := sub.opts.maxBlock(); !blocked || subMaxBlock < maxBlock {
+			maxBlock = subMaxBlock
+		}
+		blocked = true
+	}
+
+	return maxBlock, blocked
+}
+
+// notifyConsumed wakes any distributors waiting in waitForBlockingSubscribers.
+func (c *Client) notifyConsumed() {
+	c.consumedLock.Lock()
+	defer c.consumedLock.Unlock()
+
+	close(c.consumed)
+	c.consumed = make(chan struct{})
+}
+
+// Subscribe creates a new subscription for the given channel within this
+// Client, starting immediately after the latest message in the client's
+// overall message stream. See the SubscribeAt documentation for more details.
+func (c *Client) Subscribe(ch chan<- Message) error {
+	return c.SubscribeAt(-1, ch)
+}
+
+// SubscribeAt creates a new subscription for the given channel within this
+// Client, starting at the specified point in the client's overall message
+// stream. Each message sent into the channel will have an ID indicating its
+// relative position in the stream. IDs begin at 0 and increment by 1 for each
+// new message, and are unique within a single Client instance.
+//
+// Each Client maintains a bounded number of past messages from the overall
+// stream (see WithQueueSize and WithRetention). If a subscriber falls behind
+// this buffer, or is subscribed using an ID that is no longer in the buffer,
+// that subscriber will transparently be skipped forward to the earliest message
+// still remaining in the buffer. All intervening messages will be lost. If
+// necessary, subscribers can detect this behavior by watching for message ID
+// increases larger than 1, or can use SubscribeWithOptions to customize it.
+//
+// Subscriptions using IDs that have not yet appeared in the stream are
+// supported. The subscription will begin once a new message has been assigned
+// the requested ID and inserted into the stream.
+//
+// As a special case, a negative ID will begin the subscription immediately
+// after the latest message in the stream.
+//
+// If the given channel already has an active subscription,
+// ErrAlreadySubscribed will be returned.
+func (c *Client) SubscribeAt(id int, ch chan<- Message) error {
+	return c.SubscribeWithOptions(id, ch, SubscribeOptions{})
+}
+
+// SubscribeWithOptions creates a new subscription for the given channel within
+// this Client, as with SubscribeAt, using the provided options to customize how
+// the subscription behaves when it falls behind.
+func (c *Client) SubscribeWithOptions(id int, ch chan<- Message, opts SubscribeOptions) error {
+	if id < 0 {
+		c.messagesLock.RLock()
+		id = c.nextMessageID
+		c.messagesLock.RUnlock()
+	}
+
+	c.subsLock.Lock()
+	defer c.subsLock.Unlock()
+
+	if _, ok := c.subs[ch]; ok {
+		return ErrAlreadySubscribed
+	}
+
+	c.subs[ch] = newSubscription(c, id, ch, opts)
+	return nil
+}
+
+// Unsubscribe terminates the subscription for the given channel within this
+// Client. After Unsubscribe returns, the channel will no longer receive any
+// messages and may safely be closed. If the given channel was not previously
+// subscribed, ErrNotSubscribed will be returned.
+func (c *Client) Unsubscribe(ch chan<- Message) error {
+	c.subsLock.Lock()
+	defer c.subsLock.Unlock()
+
+	if _, ok := c.subs[ch]; !ok {
+		return ErrNotSubscribed
+	}
+
+	c.subs[ch].stop()
+	delete(c.subs, ch)
+	return nil
+}
+
+// SendMessage sends the given Message to its associated Slack channel.
+func (c *Client) SendMessage(m Message) {
+	c.send(m)
+}
+
+// postMessage sends a Message using the chat.postMessage Web API method, for
+// Clients whose connections cannot send messages themselves.
+func (c *Client) postMessage(m Message) {
+	_, ts, err := c.api.PostMessage(m.ChannelID, slack.MsgOptionText(m.Text, false))
+	if err != nil {
+		c.reportError(err)
+		return
+	}
+	c.IgnoreTimestamp(ts)
+}
+
+// maxIgnoredTimestamps bounds the number of timestamps that a Client will
+// remember through IgnoreTimestamp.
+const maxIgnoredTimestamps = 256
+
+// IgnoreTimestamp prevents a message with the given timestamp from being
+// distributed to this Client's subscribers. A Client automatically ignores the
+// messages that it sends, so that they are not echoed back to Readers. Other
+// WriteClients that send messages on behalf of this Client's user should call
+// IgnoreTimestamp with the timestamp of each message they send.
+//
+// Only a bounded number of the most recently ignored timestamps are retained.
+func (c *Client) IgnoreTimestamp(ts string) {
+	if ts == "" {
+		return
+	}
+
+	c.ignoredLock.Lock()
+	defer c.ignoredLock.Unlock()
+
+	if c.ignored[ts] {
+		return
+	}
+
+	c.ignored[ts] = true
+	c.ignoredList = append(c.ignoredList, ts)
+
+	if len(c.ignoredList) > maxIgnoredTimestamps {
+		delete(c.ignored, c.ignoredList[0])
+		c.ignoredList = c.ignoredList[1:]
+	}
+}
+
+func (c *Client) isIgnored(ts string) bool {
+	c.ignoredLock.Lock()
+	defer c.ignoredLock.Unlock()
+	return c.ignored[ts]
+}
+
+// Close terminates all subscriptions within this Client and disconnects from
+// Slack. The behavior of Subscribe, SubscribeAt, and Unsubscribe for a closed
+// Client is undefined.
+func (c *Client) Close() error {
+	close(c.done)
+	c.wg.Wait()
+
+	c.subsLock.Lock()
+	defer c.subsLock.Unlock()
+
+	for _, sub := range c.subs {
+		sub.stop()
+	}
+
+	// Unblock any subscribers waiting for a new message and allow them to
+	// terminate.
+	c.messagesCond.Broadcast()
+
+	// Allow for unit testing of the above subscription-related logic. A Client
+	// that has failed may already be disconnected, which is not worth an error.
+	if c.disconnect != nil {
+		if err := c.disconnect(); err != nil && c.Err() == nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package slackio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestReceiveSkipsOwnMessages(t *testing.T) {
+	client := initClient()
+	client.self.Store(Identity{UserID: "UBOT", BotID: "BBOT"})
+	defer close(client.done)
+
+	ch := make(chan Message, 10)
+	if err := client.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	for _, m := range []slack.Msg{
+		{Type: "message", Channel: "C1", User: "UBOT", BotID: "BBOT", Timestamp: "1.000001", Text: "from the bot"},
+		{Type: "message", Channel: "C1", User: "UBOT", Timestamp: "1.000002", Text: "from the bot user"},
+		{Type: "message", Channel: "C1", BotID: "BBOT", Timestamp: "1.000003", Text: "from the bot ID"},
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1.000004", Text: "from a user"},
+	} {
+		client.receive(&slack.MessageEvent{Msg: m})
+	}
+
+	if msg := receiveMessage(t, ch); msg.Text != "from a user" {
+		t.Errorf("received %q; want %q", msg.Text, "from a user")
+	}
+}
+
+func TestReceiveDoesNotLookUpIdentity(t *testing.T) {
+	// Until the Client's identity is known, messages are distributed without
+	// waiting for Slack to report it.
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+
+	apiURL := slack.APIURL
+	slack.APIURL = server.URL + "/"
+	defer func() { slack.APIURL = apiURL }()
+
+	client := initClient()
+	client.apiToken = "xoxb-test"
+	defer close(client.done)
+
+	ch := make(chan Message, 10)
+	if err := client.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	resolved := make(chan struct{})
+	go func() {
+		client.resolveIdentity()
+		close(resolved)
+	}()
+	client.receive(&slack.MessageEvent{Msg: slack.Msg{Type: "message", Channel: "C1", User: "U1", Timestamp: "1.000001", Text: "hello"}})
+
+	if msg := receiveMessage(t, ch); msg.Text != "hello" {
+		t.Errorf("received %q; want %q", msg.Text, "hello")
+	}
+	close(unblock)
+	<-resolved
+}
+
+func TestDistributeSkipsIgnoredAndOldMessages(t *testing.T) {
+	client := initClient()
+	defer close(client.done)
+
+	ch := make(chan Message, 10)
+	if err := client.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	client.IgnoreTimestamp("1.000002")
+	for _, m := range []slack.Msg{
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1.000001", Text: "first"},
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1.000002", Text: "ignored"},
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1.000001", Text: "duplicate"},
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1.000003", Text: ""},
+		{Type: "message", Channel: "C1", User: "U1", Timestamp: "1.000004", Text: "last"},
+	} {
+		client.distribute(&slack.MessageEvent{Msg: m})
+	}
+
+	for i, want := range []string{"first", "last"} {
+		msg := receiveMessage(t, ch)
+		if msg.Text != want || msg.ID != i {
+			t.Errorf("received message %d %q; want %d %q", msg.ID, msg.Text, i, want)
+		}
+	}
+
+	select {
+	case msg := <-ch:
+		t.Errorf("received unexpected message %q", msg.Text)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
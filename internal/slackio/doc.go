@@ -0,0 +1,16 @@
+/*
+
+Package slackio implements real-time communication with Slack behind io.Reader
+and io.Writer interfaces.
+
+To get started with slackio, construct a Client instance using a Slack API key.
+Then, create Reader and Writer instances as necessary using this Client. See
+the Reader and Writer examples for more details.
+
+This package is derived from version 0.2.1 of go.alexhamlin.co/slackio, which
+was originally extracted from slackbridge. It lives within slackbridge once
+again so that its Client can evolve alongside the features of slackbridge
+(e.g. support for Slack APIs other than the legacy real-time messaging API).
+
+*/
+package slackio // import "go.alexhamlin.co/slackbridge/internal/slackio"
@@ -0,0 +1,201 @@
+package slackio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/nlopes/slack"
+)
+
+// maxEventBodySize limits the size of the Events API request bodies that a
+// Client will read. Slack's payloads for message events are far smaller.
+const maxEventBodySize = 1 << 20
+
+// maxPendingEvents limits the number of Events API callbacks that a Client has
+// acknowledged but not yet distributed. Further callbacks are dropped until the
+// Client catches up.
+const maxPendingEvents = 256
+
+// eventCallback is the outer structure of an Events API payload, whether it is
+// delivered over HTTP or through Socket Mode.
+type eventCallback struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// NewEventsAPIClient returns a new Client that receives messages as Events API
+// callbacks over HTTP, serving requests from the given net.Listener. This is
+// useful in environments where outbound WebSocket connections are unavailable.
+// The Request URL of the associated Slack app must be configured to reach the
+// listener.
+//
+// Every request must carry a valid signature from Slack, which is verified
+// using signingSecret. As with Socket Mode, messages are sent using the
+// chat.postMessage Web API method with botToken. The listener is closed when the
+// Client is closed.
+func NewEventsAPIClient(l net.Listener, signingSecret, botToken string, opts ...ClientOption) *Client {
+	if signingSecret == "" || botToken == "" {
+		panic("slackio: Events API Client requires a non-blank signing secret and API token")
+	}
+
+	c := initClient(opts...)
+	c.serveEventsAPI(l, signingSecret, botToken)
+	return c
+}
+
+// NewEventsAPIClientContext is like NewEventsAPIClient, but first verifies
+// botToken using Slack's auth.test Web API method, returning ErrInvalidAuth if
+// Slack rejects it. The context bounds the verification only, not the life of
+// the Client. The listener is closed if an error is returned.
+func NewEventsAPIClientContext(ctx context.Context, l net.Listener, signingSecret, botToken string, opts ...ClientOption) (*Client, error) {
+	if signingSecret == "" || botToken == "" {
+		l.Close()
+		return nil, errors.New("slackio: Events API Client requires a non-blank signing secret and API token")
+	}
+
+	c := initClient(opts...)
+	c.apiToken = botToken
+	if _, err := c.identityContext(ctx); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	c.serveEventsAPI(l, signingSecret, botToken)
+	return c, nil
+}
+
+// serveEventsAPI starts serving Events API requests for the Client.
+func (c *Client) serveEventsAPI(l net.Listener, signingSecret, botToken string) {
+	c.api, c.apiToken = slack.New(botToken), botToken
+	c.send = c.postMessage
+
+	srv := &http.Server{Handler: newEventsHandler(c, signingSecret)}
+	c.disconnect = srv.Close
+
+	// The Events API has no connection to signal readiness, so the identity that
+	// receive relies on is looked up as soon as the Client starts serving.
+	go c.resolveIdentity()
+
+	// Serve returns http.ErrServerClosed once the Client is closed, which is the
+	// expected way for it to terminate.
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			c.fail(err)
+		}
+	}()
+}
+
+// eventsHandler is the http.Handler for an Events API Client. Slack retries
+// callbacks that are not acknowledged within 3 seconds, and distributing a
+// message can block for longer than that (see MaxBlock). So, the handler
+// acknowledges each callback as soon as it is verified, and distributes the
+// callbacks in order from a separate goroutine.
+type eventsHandler struct {
+	client        *Client
+	signingSecret string
+	pending       chan []byte
+}
+
+// newEventsHandler returns an eventsHandler for c, and starts distributing the
+// callbacks that it accepts until c is closed.
+func newEventsHandler(c *Client, signingSecret string) *eventsHandler {
+	h := &eventsHandler{
+		client:        c,
+		signingSecret: signingSecret,
+		pending:       make(chan []byte, maxPendingEvents),
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			select {
+			case payload := <-h.pending:
+				c.handleEventCallback(payload)
+			case <-c.done:
+				return
+			}
+		}
+	}()
+
+	return h
+}
+
+func (h *eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	verifier, err := slack.NewSecretsVerifier(r.Header, h.signingSecret)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.TeeReader(io.LimitReader(r.Body, maxEventBodySize), &verifier))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := verifier.Ensure(); err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var cb eventCallback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	switch cb.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, cb.Challenge)
+
+	case "event_callback":
+		// Retries are only sent for callbacks that we failed to acknowledge in
+		// time, which we have most likely accepted already.
+		if r.Header.Get("X-Slack-Retry-Num") == "" {
+			h.enqueue(body)
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// enqueue hands off a verified callback for distribution, dropping it if too
+// many callbacks are already pending.
+func (h *eventsHandler) enqueue(payload []byte) {
+	select {
+	case h.pending <- payload:
+	default:
+		h.client.reportError(errors.New("slackio: too many pending Events API callbacks; dropping an event"))
+	}
+}
+
+// handleEventCallback distributes the message contained within an Events API
+// payload, if any.
+func (c *Client) handleEventCallback(payload []byte) {
+	var cb eventCallback
+	if err := json.Unmarshal(payload, &cb); err != nil || cb.Type != "event_callback" {
+		return
+	}
+
+	var m slack.MessageEvent
+	if err := json.Unmarshal(cb.Event, &m); err != nil {
+		return
+	}
+
+	c.receive(&m)
+}
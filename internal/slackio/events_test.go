@@ -0,0 +1,91 @@
+package slackio
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsHandlerAcknowledgesBeforeDistributing(t *testing.T) {
+	client := initClient()
+	defer close(client.done)
+	h := newEventsHandler(client, "secret")
+
+	ch := make(chan Message, 10)
+	if err := client.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	// Holding the event lock blocks distribution, as a blocking subscriber
+	// would.
+	client.eventLock.Lock()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedEventRequest(t, "secret", "C1", "1.000001", "hello", ""))
+	client.eventLock.Unlock()
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusOK)
+	}
+	if msg := receiveMessage(t, ch); msg.Text != "hello" {
+		t.Errorf("received %q; want %q", msg.Text, "hello")
+	}
+}
+
+func TestEventsHandlerIgnoresRetries(t *testing.T) {
+	client := initClient()
+	defer close(client.done)
+	h := newEventsHandler(client, "secret")
+
+	ch := make(chan Message, 10)
+	if err := client.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	for _, req := range []*http.Request{
+		signedEventRequest(t, "secret", "C1", "1.000001", "retried", "1"),
+		signedEventRequest(t, "secret", "C1", "1.000002", "done", ""),
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d; want %d", w.Code, http.StatusOK)
+		}
+	}
+
+	if msg := receiveMessage(t, ch); msg.Text != "done" {
+		t.Errorf("received %q; want %q", msg.Text, "done")
+	}
+}
+
+// signedEventRequest returns an Events API request for a message callback,
+// signed with secret. If retry is not blank, the request is marked as a retry
+// with that number.
+func signedEventRequest(t *testing.T, secret, channel, ts, text, retry string) *http.Request {
+	t.Helper()
+
+	body := fmt.Sprintf(
+		`{"type":"event_callback","event":{"type":"message","channel":%q,"user":"U1","ts":%q,"text":%q}}`,
+		channel, ts, text)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	if retry != "" {
+		req.Header.Set("X-Slack-Retry-Num", retry)
+	}
+	return req
+}
@@ -0,0 +1,30 @@
+package slackio
+
+// Message is the type for messages received from and sent to a single Slack
+// channel.
+type Message struct {
+	ID        int
+	ChannelID string
+	Text      string
+
+	// Timestamp is the timestamp that Slack assigned to a received message,
+	// which uniquely identifies it within its channel. It is blank for messages
+	// that have not yet been sent.
+	Timestamp string
+
+	// UserID is the ID of the user who sent a received message. For messages
+	// sent by integrations that are not associated with a user, it is the ID of
+	// the bot instead.
+	UserID string
+
+	// ThreadTimestamp is the timestamp of the parent message for a received
+	// thread reply, and is blank for messages in the main body of a channel.
+	// Readers only output thread replies if configured to (see ReaderOptions).
+	// WriteClients that support threads send messages with a ThreadTimestamp
+	// as replies; others ignore it.
+	ThreadTimestamp string
+
+	// Broadcast is set for thread replies that were (or should be) also sent to
+	// the main body of the channel.
+	Broadcast bool
+}
@@ -0,0 +1,114 @@
+package slackio
+
+import "time"
+
+// DefaultQueueSize is the maximum size of a Client's message queue, unless
+// customized using WithQueueSize. It balances memory usage with the ability to
+// subscribe at a past point in the stream using SubscribeAt.
+const DefaultQueueSize = 16
+
+// DefaultMaxBlock is the longest that a subscription using OverflowBlock will
+// delay the distribution of new messages, unless customized through its
+// SubscribeOptions.
+const DefaultMaxBlock = 30 * time.Second
+
+// DefaultBackfillChannels and DefaultBackfillMaxAge limit the channels that a
+// Client backfills after reconnecting to Slack, unless customized using
+// WithBackfill.
+const (
+	DefaultBackfillChannels = 50
+	DefaultBackfillMaxAge   = 24 * time.Hour
+)
+
+// ClientOption customizes the behavior of a Client at construction.
+type ClientOption func(*Client)
+
+// WithQueueSize sets the maximum number of past messages retained in a
+// Client's message queue. A value of 0 or less removes the limit, in which case
+// WithRetention should be used to bound the queue instead.
+func WithQueueSize(n int) ClientOption {
+	return func(c *Client) {
+		c.queueSize = n
+	}
+}
+
+// WithRetention sets the maximum age of the messages retained in a Client's
+// message queue, in addition to any limit on its size. A value of 0 or less
+// (the default) removes the limit.
+func WithRetention(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.retention = d
+	}
+}
+
+// WithBackfill limits the channels that a Client backfills after reconnecting
+// to Slack, fetching the messages it missed while disconnected. Only the
+// channels whose latest messages were received within maxAge are backfilled,
+// and only the most recent n of those. A negative n or a maxAge of 0 or less
+// removes the corresponding limit, and an n of 0 disables backfilling.
+func WithBackfill(n int, maxAge time.Duration) ClientOption {
+	return func(c *Client) {
+		c.backfillChannels = n
+		c.backfillMaxAge = maxAge
+	}
+}
+
+// WithErrorHandler sets a function to be called with recoverable errors that
+// occur in the background, such as failed connection attempts that will be
+// retried, or failures to send messages or backfill history. It may be called
+// from multiple goroutines. Unrecoverable errors are reported through the
+// Client's Failed and Err methods instead.
+func WithErrorHandler(handler func(error)) ClientOption {
+	return func(c *Client) {
+		c.errorHandler = handler
+	}
+}
+
+// OverflowPolicy determines how a subscription behaves when it falls behind
+// its Client's message queue (i.e. when messages that it has not yet received
+// would be removed from the queue).
+type OverflowPolicy int
+
+const (
+	// OverflowSkip transparently skips the subscription forward to the earliest
+	// message remaining in the queue, losing all intervening messages.
+	OverflowSkip OverflowPolicy = iota
+
+	// OverflowBlock delays the distribution of new messages until the
+	// subscription catches up, or until its MaxBlock duration elapses. In the
+	// latter case, the subscription skips forward as with OverflowReport.
+	// Note that blocking applies backpressure to all of the Client's
+	// subscriptions, as well as to its connection to Slack.
+	OverflowBlock
+
+	// OverflowReport skips the subscription forward as with OverflowSkip, but
+	// reports the number of lost messages through its OnDrop function.
+	OverflowReport
+)
+
+// SubscribeOptions customizes the behavior of a subscription. The zero value
+// provides the default behavior of SubscribeAt.
+type SubscribeOptions struct {
+	// Overflow determines how the subscription behaves when it falls behind.
+	Overflow OverflowPolicy
+
+	// MaxBlock is the longest that a subscription using OverflowBlock will
+	// delay the distribution of each new message. If 0 or less, DefaultMaxBlock
+	// is used.
+	MaxBlock time.Duration
+
+	// OnDrop, if non-nil, is called whenever a subscription using OverflowBlock
+	// or OverflowReport skips forward, with the number of messages lost from
+	// each channel keyed by channel ID. Messages lost so long ago that the
+	// Client no longer knows their channels are counted under the empty string.
+	// It is called from the goroutine that delivers messages to the
+	// subscription, so it must not block for long.
+	OnDrop func(lost map[string]int)
+}
+
+func (o SubscribeOptions) maxBlock() time.Duration {
+	if o.MaxBlock <= 0 {
+		return DefaultMaxBlock
+	}
+	return o.MaxBlock
+}
@@ -0,0 +1,159 @@
+package slackio
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// ReadClient represents objects that allow subscription to a stream of slackio
+// Messages. Note that in slackio, Client implements this interface.
+type ReadClient interface {
+	Subscribe(chan<- Message) error
+	Unsubscribe(chan<- Message) error
+}
+
+// Reader reads messages from the main body of one or more Slack channels, and
+// optionally from their threads.
+type Reader struct {
+	client    ReadClient
+	channelID string
+	opts      ReaderOptions
+	msgCh     chan Message
+	wg        sync.WaitGroup
+	readOut   io.ReadCloser
+	readIn    io.WriteCloser
+}
+
+// ReaderOptions customizes the messages that a Reader outputs. The zero value
+// provides the default behavior of NewReader.
+type ReaderOptions struct {
+	// IncludeThreads causes the Reader to output thread replies in addition to
+	// messages from the main body of each channel. Replies that were also sent
+	// to the channel (see Message.Broadcast) are output once, as replies.
+	IncludeThreads bool
+
+	// Filter, if non-nil, limits the Reader's output to the messages for which
+	// it returns true. It is called after the Reader has applied its own channel
+	// and thread checks, with the full text of each message.
+	Filter func(Message) bool
+
+	// Format, if non-nil, returns the text that the Reader outputs for each line
+	// of a message, given a copy of the message whose Text is that line. This
+	// allows output to identify the channel or user behind each line. The
+	// returned text should not include a trailing newline.
+	Format func(Message) string
+
+	// ThreadPrefix, if non-nil, returns text that the Reader prepends to each
+	// line of a thread reply (e.g. a reference to its parent message), allowing
+	// replies to be distinguished from the main body of the channel. It is
+	// applied to the output of Format.
+	ThreadPrefix func(Message) string
+
+	// OnOutput, if non-nil, is called with each message that the Reader outputs,
+	// once all of its text has been returned by Read. Messages that the Reader
+	// skips, or that are cut off by Close, are never passed to OnOutput. It is
+	// called from the goroutine that processes the Reader's messages, so it
+	// must not block for long.
+	OnOutput func(Message)
+}
+
+// NewReader returns a new Reader. If channelID is non-blank, the Reader will
+// only output text from a single channel. Otherwise, it will output text from
+// all channels together in a single stream. Thread replies are not output.
+func NewReader(client ReadClient, channelID string) *Reader {
+	return NewReaderWithOptions(client, channelID, ReaderOptions{})
+}
+
+// NewReaderWithOptions is like NewReader, but uses the provided options to
+// customize the messages that the Reader outputs.
+func NewReaderWithOptions(client ReadClient, channelID string, opts ReaderOptions) *Reader {
+	c := &Reader{
+		client:    client,
+		channelID: channelID,
+		opts:      opts,
+		msgCh:     make(chan Message, 1),
+	}
+
+	c.readOut, c.readIn = io.Pipe()
+	c.client.Subscribe(c.msgCh)
+
+	// Process incoming reads from the Client; note that the stream channel
+	// will be drained until it is closed
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		for msg := range c.msgCh {
+			if c.channelID != "" && msg.ChannelID != c.channelID {
+				continue
+			}
+			if msg.ThreadTimestamp != "" && !c.opts.IncludeThreads {
+				continue
+			}
+			if c.opts.Filter != nil && !c.opts.Filter(msg) {
+				continue
+			}
+
+			// When this Reader is closed, this call returns an io.ErrClosedPipe.
+			// This is the only possible error if we don't close readOut, and it can
+			// be safely ignored. Otherwise, the pipe only returns once Read has
+			// consumed all of the text.
+			if _, err := c.readIn.Write([]byte(c.opts.Render(msg))); err == nil && c.opts.OnOutput != nil {
+				c.opts.OnOutput(msg)
+			}
+		}
+	}()
+
+	return c
+}
+
+// Render returns the text that a Reader with these options outputs for the
+// given message, terminated with a newline. It ignores IncludeThreads, Filter,
+// and OnOutput, which do not affect the text of a message.
+func (o ReaderOptions) Render(msg Message) string {
+	var prefix string
+	if msg.ThreadTimestamp != "" && o.ThreadPrefix != nil {
+		prefix = o.ThreadPrefix(msg)
+	}
+	if prefix == "" && o.Format == nil {
+		return msg.Text + "\n"
+	}
+
+	lines := strings.Split(msg.Text, "\n")
+	for i, line := range lines {
+		if o.Format != nil {
+			lineMsg := msg
+			lineMsg.Text = line
+			line = o.Format(lineMsg)
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Read returns text from the main body of one or more Slack channels (and from
+// their threads, if configured), buffered by line. Single messages will be
+// terminated with an appended newline. Messages with explicit line breaks are
+// equivalent to multiple single messages in succession.
+func (c *Reader) Read(p []byte) (int, error) {
+	return c.readOut.Read(p)
+}
+
+// Close disconnects this Reader from Slack and shuts down internal buffers.
+// After calling Close, the next call to Read will result in an EOF.
+func (c *Reader) Close() error {
+	if err := c.client.Unsubscribe(c.msgCh); err != nil {
+		// This is a catastrophic situation likely indicating corruption of the
+		// Client's subscription pool.
+		panic(err)
+	}
+
+	// Closing the write half of the pipe forces Read to return EOF and Write
+	// to return ErrClosedPipe. The call itself always returns nil.
+	c.readIn.Close()
+	close(c.msgCh)
+	c.wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package slackio
+
+import (
+	"bufio"
+	"reflect"
+	"testing"
+)
+
+type testReadClient struct {
+	subscribed chan chan<- Message
+}
+
+func (c *testReadClient) Subscribe(ch chan<- Message) error {
+	c.subscribed <- ch
+	return nil
+}
+
+func (c *testReadClient) Unsubscribe(ch chan<- Message) error {
+	return nil
+}
+
+func TestReader(t *testing.T) {
+	client := &testReadClient{subscribed: make(chan chan<- Message, 1)}
+
+	var output []string
+	reader := NewReaderWithOptions(client, "C1", ReaderOptions{
+		OnOutput: func(m Message) { output = append(output, m.Timestamp) },
+	})
+	ch := <-client.subscribed
+
+	go func() {
+		ch <- Message{ChannelID: "C1", Timestamp: "1.1", Text: "hello\nworld"}
+		ch <- Message{ChannelID: "C2", Timestamp: "1.2", Text: "other channel"}
+		ch <- Message{ChannelID: "C1", Timestamp: "1.3", ThreadTimestamp: "1.1", Text: "reply"}
+		ch <- Message{ChannelID: "C1", Timestamp: "1.4", Text: "bye"}
+	}()
+
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	for len(lines) < 3 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	reader.Close()
+
+	if want := []string{"hello", "world", "bye"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("read %q; want %q", lines, want)
+	}
+	if want := []string{"1.1", "1.4"}; !reflect.DeepEqual(output, want) {
+		t.Errorf("output messages %q; want %q", output, want)
+	}
+}
+
+func TestReaderOptionsRender(t *testing.T) {
+	format := func(m Message) string { return m.UserID + ": " + m.Text }
+	prefix := func(m Message) string { return "> " }
+
+	testCases := []struct {
+		opts ReaderOptions
+		msg  Message
+		want string
+	}{
+		{ReaderOptions{}, Message{Text: "hello"}, "hello\n"},
+		{ReaderOptions{}, Message{Text: "one\ntwo"}, "one\ntwo\n"},
+		{ReaderOptions{Format: format}, Message{UserID: "U1", Text: "one\ntwo"}, "U1: one\nU1: two\n"},
+		{ReaderOptions{ThreadPrefix: prefix}, Message{Text: "hello"}, "hello\n"},
+		{ReaderOptions{ThreadPrefix: prefix}, Message{ThreadTimestamp: "1.1", Text: "one\ntwo"}, "> one\n> two\n"},
+		{ReaderOptions{Format: format, ThreadPrefix: prefix}, Message{UserID: "U1", ThreadTimestamp: "1.1", Text: "hi"}, "> U1: hi\n"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.opts.Render(tc.msg); got != tc.want {
+			t.Errorf("Render(%+v) = %q; want %q", tc.msg, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package slackio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nlopes/slack"
+)
+
+// Socket Mode connections are reestablished after failures, waiting for an
+// exponentially increasing interval between these bounds before each attempt.
+const (
+	socketModeMinBackoff = time.Second
+	socketModeMaxBackoff = time.Minute
+)
+
+// socketModeEnvelope is the outer structure of every message that Slack sends
+// over a Socket Mode connection.
+type socketModeEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"`
+}
+
+// socketModeAck acknowledges receipt of an envelope. Slack will retry the
+// delivery of envelopes that are not acknowledged in a timely manner.
+type socketModeAck struct {
+	EnvelopeID string `json:"envelope_id"`
+}
+
+// NewSocketModeClient returns a new Client and connects it to Slack using
+// Socket Mode. appToken must be an app-level token (beginning with "xapp-")
+// with the connections:write scope, and is used to open the WebSocket
+// connection through which messages are received. Socket Mode connections
+// cannot send messages, so the Client instead sends them using the
+// chat.postMessage Web API method with botToken. If Slack rejects either token,
+// the Client fails with ErrInvalidAuth (see Failed and Err).
+//
+// To receive messages, the Slack app associated with the tokens must subscribe
+// to the appropriate message events (e.g. message.channels and message.im).
+func NewSocketModeClient(appToken, botToken string, opts ...ClientOption) *Client {
+	if appToken == "" || botToken == "" {
+		panic("slackio: Socket Mode Client requires non-blank API tokens")
+	}
+
+	c := initClient(opts...)
+	c.connectSocketMode(appToken, botToken)
+	return c
+}
+
+// NewSocketModeClientContext is like NewSocketModeClient, but first verifies
+// both tokens with Slack, returning ErrInvalidAuth if Slack rejects either of
+// them. The context bounds the verification only, not the life of the Client.
+func NewSocketModeClientContext(ctx context.Context, appToken, botToken string, opts ...ClientOption) (*Client, error) {
+	if appToken == "" || botToken == "" {
+		return nil, errors.New("slackio: Socket Mode Client requires non-blank API tokens")
+	}
+
+	c := initClient(opts...)
+	c.apiToken = botToken
+	if _, err := c.identityContext(ctx); err != nil {
+		return nil, err
+	}
+
+	// The URL itself is discarded, as Slack expires unused connection URLs.
+	var resp struct{}
+	if err := callAPIContext(ctx, "apps.connections.open", appToken, &resp); err != nil {
+		return nil, err
+	}
+
+	c.connectSocketMode(appToken, botToken)
+	return c, nil
+}
+
+// connectSocketMode starts maintaining the Socket Mode connection of the
+// Client.
+func (c *Client) connectSocketMode(appToken, botToken string) {
+	c.api, c.apiToken = slack.New(botToken), botToken
+	c.send = c.postMessage
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.runSocketMode(appToken)
+	}()
+}
+
+// runSocketMode maintains a Socket Mode connection until the Client is closed,
+// reconnecting as necessary.
+func (c *Client) runSocketMode(appToken string) {
+	backoff := socketModeMinBackoff
+
+	for {
+		connected, err := c.serveSocketMode(appToken)
+		if err == ErrInvalidAuth {
+			c.fail(err)
+			return
+		}
+
+		select {
+		case <-c.done:
+			return
+		default:
+			if err != nil {
+				c.reportError(err)
+			}
+		}
+
+		// Slack routinely asks Socket Mode clients to reconnect (e.g. when a
+		// connection is about to be refreshed), so a connection that was
+		// successfully established can be replaced immediately. Otherwise, we back
+		// off to avoid hammering Slack during an outage.
+		var wait <-chan time.Time
+		if connected {
+			backoff = socketModeMinBackoff
+		} else {
+			wait = time.After(backoff)
+			if backoff *= 2; backoff > socketModeMaxBackoff {
+				backoff = socketModeMaxBackoff
+			}
+		}
+
+		if wait != nil {
+			select {
+			case <-wait:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// serveSocketMode opens a single Socket Mode connection and processes
+// envelopes from it until the connection fails, Slack requests a disconnect,
+// or the Client is closed. connected reports whether Slack greeted the
+// connection with a "hello" envelope.
+func (c *Client) serveSocketMode(appToken string) (connected bool, err error) {
+	wsURL, err := openSocketModeConnection(appToken)
+	if err != nil {
+		return false, err
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Closing the connection is the only way to interrupt a blocked read, so we
+	// watch for closure of the Client alongside the read loop.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-c.done:
+		case <-stop:
+		}
+		conn.Close()
+	}()
+
+	for {
+		var env socketModeEnvelope
+		if err := conn.ReadJSON(&env); err != nil {
+			return connected, err
+		}
+
+		if env.EnvelopeID != "" {
+			if err := conn.WriteJSON(socketModeAck{env.EnvelopeID}); err != nil {
+				return connected, err
+			}
+		}
+
+		switch env.Type {
+		case "hello":
+			// backfill holds back messages from the channels it backfills
+			// before returning, so envelopes that arrive over this connection
+			// are distributed after any backfilled messages.
+			connected = true
+			c.resolveIdentity()
+			c.backfill()
+
+		case "disconnect":
+			return connected, nil
+
+		case "events_api":
+			c.handleEventCallback(env.Payload)
+		}
+	}
+}
+
+// openSocketModeConnection requests a new Socket Mode WebSocket URL from
+// Slack's apps.connections.open Web API method.
+func openSocketModeConnection(appToken string) (string, error) {
+	var resp struct {
+		URL string `json:"url"`
+	}
+	if err := callAPI("apps.connections.open", appToken, &resp); err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
@@ -0,0 +1,162 @@
+package slackio_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+	"go.alexhamlin.co/slackbridge/internal/slacktest"
+)
+
+const testTimeout = 5 * time.Second
+
+// startServer starts a slacktest.Server and points the Slack library at it. The
+// returned function shuts down the Server and restores the library's APIURL.
+func startServer(t *testing.T) (*slacktest.Server, func()) {
+	server := slacktest.NewServer()
+	apiURL := slack.APIURL
+	slack.APIURL = server.URL
+
+	return server, func() {
+		slack.APIURL = apiURL
+		server.Close()
+	}
+}
+
+// testClient checks that client receives messages sent by users, and that it
+// does not receive the messages that it posts itself.
+func testClient(t *testing.T, server *slacktest.Server, client *slackio.Client) {
+	ch := make(chan slackio.Message, 10)
+	if err := client.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	server.SendMessage("CGENERAL0", "UHUMAN000", "hello")
+	if msg := receiveMessage(t, ch); msg.Text != "hello" || msg.UserID != "UHUMAN000" || msg.ChannelID != "CGENERAL0" {
+		t.Errorf("received %+v; want hello from UHUMAN000 in CGENERAL0", msg)
+	}
+
+	client.SendMessage(slackio.Message{ChannelID: "CGENERAL0", Text: "reply"})
+	if _, err := server.WaitForPost(1, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	server.SendMessage("CGENERAL0", "UHUMAN000", "again")
+	if msg := receiveMessage(t, ch); msg.Text != "again" {
+		t.Errorf("received %q; want %q", msg.Text, "again")
+	}
+
+	select {
+	case msg := <-ch:
+		t.Errorf("received unexpected message %q", msg.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func receiveMessage(t *testing.T, ch <-chan slackio.Message) slackio.Message {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for message")
+		return slackio.Message{}
+	}
+}
+
+func TestSocketModeClient(t *testing.T) {
+	server, cleanup := startServer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	client, err := slackio.NewSocketModeClientContext(ctx, "xapp-test", "xoxb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := server.WaitForConnection(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	testClient(t, server, client)
+}
+
+func TestSocketModeClientInvalidAuth(t *testing.T) {
+	server, cleanup := startServer(t)
+	defer cleanup()
+
+	server.RevokeToken("xoxb-test")
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	if _, err := slackio.NewSocketModeClientContext(ctx, "xapp-test", "xoxb-test"); err != slackio.ErrInvalidAuth {
+		t.Errorf("NewSocketModeClientContext error = %v; want %v", err, slackio.ErrInvalidAuth)
+	}
+}
+
+func TestEventsAPIClient(t *testing.T) {
+	server, cleanup := startServer(t)
+	defer cleanup()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	client, err := slackio.NewEventsAPIClientContext(ctx, l, "secret", "xoxb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server.SetEventsRequestURL("http://"+l.Addr().String()+"/", "secret")
+	testClient(t, server, client)
+}
+
+func TestSocketModeClientBackfill(t *testing.T) {
+	server, cleanup := startServer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	client, err := slackio.NewSocketModeClientContext(ctx, "xapp-test", "xoxb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := server.WaitForConnection(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan slackio.Message, 10)
+	if err := client.Subscribe(ch); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	server.SendMessage("CGENERAL0", "UHUMAN000", "hello")
+	receiveMessage(t, ch)
+
+	// Messages sent while the client is disconnected are fetched from history
+	// once it reconnects.
+	server.Disconnect()
+	server.SendMessage("CGENERAL0", "UHUMAN000", "missed")
+
+	if msg := receiveMessage(t, ch); msg.Text != "missed" {
+		t.Errorf("received %q; want %q", msg.Text, "missed")
+	}
+}
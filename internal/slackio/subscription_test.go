@@ -0,0 +1,58 @@
+package slackio
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestSubscriptionReportsLostMessagesByChannel(t *testing.T) {
+	client := initClient(WithQueueSize(2))
+	defer close(client.done)
+
+	for _, channelID := range []string{"C1", "C2", "C1", "C3", "C3"} {
+		client.distribute(&slack.MessageEvent{Msg: slack.Msg{
+			Type:    "message",
+			Channel: channelID,
+			Text:    "hello",
+		}})
+	}
+
+	drops := make(chan map[string]int, 1)
+	ch := make(chan Message)
+	err := client.SubscribeWithOptions(0, ch, SubscribeOptions{
+		Overflow: OverflowReport,
+		OnDrop:   func(lost map[string]int) { drops <- lost },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Unsubscribe(ch)
+
+	select {
+	case msg := <-ch:
+		if msg.ID != 3 {
+			t.Errorf("first message has ID %d; want 3", msg.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	want := map[string]int{"C1": 2, "C2": 1}
+	if lost := <-drops; !reflect.DeepEqual(lost, want) {
+		t.Errorf("lost %v; want %v", lost, want)
+	}
+}
+
+func TestLostMessagesBeforeEvictedRecord(t *testing.T) {
+	client := initClient()
+	client.evicted = []string{"C1", "C2"}
+	client.evictedStart = 10
+
+	want := map[string]int{"": 3, "C1": 1}
+	if lost := client.lostMessages(7, 11); !reflect.DeepEqual(lost, want) {
+		t.Errorf("lost %v; want %v", lost, want)
+	}
+}
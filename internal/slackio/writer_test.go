@@ -0,0 +1,55 @@
+package slackio
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testWriteClient struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (c *testWriteClient) SendMessage(m Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, m)
+}
+
+func TestWriter(t *testing.T) {
+	client := &testWriteClient{}
+	writer := NewWriter(client, "C12345678", LineBatcher)
+
+	if _, err := writer.Write([]byte("one\ntwo\nthr")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte("ee")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Message{
+		{ChannelID: "C12345678", Text: "one"},
+		{ChannelID: "C12345678", Text: "two"},
+		{ChannelID: "C12345678", Text: "three"},
+	}
+	if !reflect.DeepEqual(client.sent, want) {
+		t.Errorf("sent %v; want %v", client.sent, want)
+	}
+
+	if _, err := writer.Write([]byte("four\n")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
+
+func TestWriterRequiresChannel(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewWriter with a blank channel ID did not panic")
+		}
+	}()
+	NewWriter(&testWriteClient{}, "", nil)
+}
@@ -0,0 +1,776 @@
+/*
+
+Package slacktest implements a local stand-in for Slack, allowing slackbridge
+to be exercised end-to-end without access to a real workspace.
+
+A Server speaks enough of Slack's Web API, real-time messaging (RTM) API,
+Socket Mode, and Events API to support the features of slackbridge. Tests
+inject messages from simulated users with SendMessage, and inspect the messages
+that slackbridge posted with Posted or WaitForPost. To point slackbridge at a
+Server, set the Slack library's APIURL variable (or slackbridge's SLACK_API_URL
+environment variable) to the Server's URL.
+
+As with Slack, messages posted through chat.postMessage are delivered back to
+connected clients, so that tests can verify that slackbridge does not echo its
+own messages.
+
+The Server is deliberately permissive: any non-blank token is accepted unless it
+is revoked with RevokeToken, and only the parameters that slackbridge actually
+uses are interpreted.
+
+*/
+package slacktest // import "go.alexhamlin.co/slackbridge/internal/slacktest"
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nlopes/slack"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// Identities used by a Server for the user associated with the API token and
+// for its workspace.
+const (
+	BotUserID = "UBOT00000"
+	BotID     = "BBOT00000"
+	BotName   = "slackbridge"
+	TeamID    = "TTEAM0000"
+	TeamName  = "slacktest"
+)
+
+// ErrTimeout is returned when a Server's wait methods time out.
+var ErrTimeout = errors.New("slacktest: timed out")
+
+// Message is a message received or sent by a Server.
+type Message struct {
+	ChannelID       string
+	UserID          string
+	Text            string
+	Timestamp       string
+	ThreadTimestamp string
+
+	// Broadcast is set for thread replies that were also sent to the channel.
+	Broadcast bool
+
+	// The following fields are only set for messages posted through
+	// chat.postMessage.
+	Username  string
+	IconEmoji string
+	IconURL   string
+}
+
+// Server is a local stand-in for Slack. The zero value is not usable; create
+// Servers with NewServer.
+type Server struct {
+	// URL is the base URL for Web API requests to this Server, including a
+	// trailing slash, in the form expected by the Slack library's APIURL.
+	URL string
+
+	httpServer *httptest.Server
+	upgrader   websocket.Upgrader
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	seq        int
+	channels   map[string]slack.Channel
+	users      map[string]slack.User
+	history    map[string][]Message
+	posted     []Message
+	rtmConns   map[*wsConn]bool
+	socketConn map[*wsConn]bool
+	eventsURL  string
+	secret     string
+	revoked    map[string]bool
+}
+
+// wsConn serializes writes to a WebSocket connection, which gorilla/websocket
+// does not support concurrently.
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *wsConn) writeJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.WriteJSON(v)
+}
+
+// NewServer starts and returns a new Server listening on a local address. A
+// single public channel named "general" is created with ID "CGENERAL0".
+func NewServer() *Server {
+	s := &Server{
+		channels:   make(map[string]slack.Channel),
+		users:      make(map[string]slack.User),
+		history:    make(map[string][]Message),
+		rtmConns:   make(map[*wsConn]bool),
+		socketConn: make(map[*wsConn]bool),
+		revoked:    make(map[string]bool),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	// The Slack library sets an Origin of https://api.slack.com on its RTM
+	// connections, which would fail the Upgrader's default same-origin check.
+	s.upgrader.CheckOrigin = func(*http.Request) bool { return true }
+
+	general := slack.Channel{IsChannel: true, IsMember: true, IsGeneral: true}
+	general.ID = "CGENERAL0"
+	general.Name = "general"
+	general.NumMembers = 1
+	s.AddChannel(general)
+	s.AddUser(slack.User{ID: BotUserID, Name: BotName, IsBot: true})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.handleAPI)
+	mux.HandleFunc("/ws/rtm", s.handleRTM)
+	mux.HandleFunc("/ws/socket", s.handleSocketMode)
+
+	s.httpServer = httptest.NewServer(mux)
+	s.URL = s.httpServer.URL + "/api/"
+	return s
+}
+
+// Close disconnects all clients and shuts down the Server.
+func (s *Server) Close() {
+	s.Disconnect()
+	s.httpServer.Close()
+}
+
+// Disconnect abruptly closes all RTM and Socket Mode connections to the Server,
+// simulating a network failure. Clients may reconnect afterward.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.rtmConns {
+		c.Close()
+	}
+	for c := range s.socketConn {
+		c.Close()
+	}
+}
+
+// RevokeToken causes the Server to reject all further requests made with the
+// given token, and disconnects all current WebSocket clients so that they must
+// authenticate again.
+func (s *Server) RevokeToken(token string) {
+	s.mu.Lock()
+	s.revoked[token] = true
+	s.mu.Unlock()
+
+	s.Disconnect()
+}
+
+// AddChannel makes a conversation available through the conversations.* Web
+// API methods, replacing any existing conversation with the same ID.
+func (s *Server) AddChannel(ch slack.Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[ch.ID] = ch
+}
+
+// AddUser adds a user to the Server, or replaces the user with the same ID. The
+// Server knows only its own bot user by default; messages may be sent on behalf
+// of unknown users, but their information can't be looked up.
+func (s *Server) AddUser(user slack.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+// SetEventsRequestURL configures the Server to deliver each message sent with
+// SendMessage as an Events API callback to the given URL, signed using the
+// given signing secret. A blank URL disables delivery.
+func (s *Server) SetEventsRequestURL(url, signingSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventsURL = url
+	s.secret = signingSecret
+}
+
+// SendMessage simulates a message sent by a user to a channel. The message is
+// recorded in the channel's history, and delivered to all connected RTM and
+// Socket Mode clients and the configured Events API request URL (if any). It
+// returns the timestamp assigned to the message.
+func (s *Server) SendMessage(channelID, userID, text string) string {
+	return s.SendThreadReply(channelID, userID, "", text)
+}
+
+// SendThreadReply is like SendMessage, but sends the message as a reply within
+// the thread identified by threadTimestamp.
+func (s *Server) SendThreadReply(channelID, userID, threadTimestamp, text string) string {
+	return s.sendMessage(Message{
+		ChannelID:       channelID,
+		UserID:          userID,
+		Text:            text,
+		ThreadTimestamp: threadTimestamp,
+	})
+}
+
+// SendThreadBroadcast is like SendThreadReply, but also sends the reply to the
+// main body of the channel (as with Slack's "Also send to channel" option).
+func (s *Server) SendThreadBroadcast(channelID, userID, threadTimestamp, text string) string {
+	return s.sendMessage(Message{
+		ChannelID:       channelID,
+		UserID:          userID,
+		Text:            text,
+		ThreadTimestamp: threadTimestamp,
+		Broadcast:       true,
+	})
+}
+
+func (s *Server) sendMessage(msg Message) string {
+	s.mu.Lock()
+	msg.Timestamp = s.nextTimestamp()
+	s.history[msg.ChannelID] = append(s.history[msg.ChannelID], msg)
+	s.mu.Unlock()
+
+	s.deliver(msg)
+	return msg.Timestamp
+}
+
+// deliver sends a message event to all connected RTM and Socket Mode clients
+// and the configured Events API request URL (if any).
+func (s *Server) deliver(msg Message) {
+	s.mu.Lock()
+	rtmConns := make([]*wsConn, 0, len(s.rtmConns))
+	for c := range s.rtmConns {
+		rtmConns = append(rtmConns, c)
+	}
+	socketConns := make([]*wsConn, 0, len(s.socketConn))
+	for c := range s.socketConn {
+		socketConns = append(socketConns, c)
+	}
+	eventsURL, secret := s.eventsURL, s.secret
+	s.mu.Unlock()
+
+	event := messageEvent(msg)
+	for _, c := range rtmConns {
+		c.writeJSON(event)
+	}
+
+	callback := map[string]interface{}{
+		"type":    "event_callback",
+		"team_id": TeamID,
+		"event":   event,
+	}
+	for _, c := range socketConns {
+		c.writeJSON(map[string]interface{}{
+			"type":        "events_api",
+			"envelope_id": "env-" + msg.Timestamp,
+			"payload":     callback,
+		})
+	}
+	if eventsURL != "" {
+		postEvent(eventsURL, secret, callback)
+	}
+}
+
+// Posted returns all messages that clients have posted to the Server, in the
+// order that they were received.
+func (s *Server) Posted() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.posted...)
+}
+
+// WaitForPost waits for clients to have posted at least n messages in total,
+// and returns the nth message (counting from 1). If fewer than n messages are
+// posted within the given timeout, ErrTimeout is returned.
+func (s *Server) WaitForPost(n int, timeout time.Duration) (Message, error) {
+	var msg Message
+	err := s.waitFor(timeout, func() bool {
+		if len(s.posted) < n {
+			return false
+		}
+		msg = s.posted[n-1]
+		return true
+	})
+	return msg, err
+}
+
+// WaitForConnection waits for at least one RTM or Socket Mode client to be
+// connected to the Server, so that messages sent with SendMessage will be
+// delivered. If no client connects within the given timeout, ErrTimeout is
+// returned.
+func (s *Server) WaitForConnection(timeout time.Duration) error {
+	return s.waitFor(timeout, func() bool {
+		return len(s.rtmConns)+len(s.socketConn) > 0
+	})
+}
+
+// waitFor waits until cond returns true or the timeout expires. cond is called
+// with s.mu held.
+func (s *Server) waitFor(timeout time.Duration, cond func() bool) error {
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !cond() {
+		if !time.Now().Before(deadline) {
+			return ErrTimeout
+		}
+		s.cond.Wait()
+	}
+
+	return nil
+}
+
+// nextTimestamp returns a new, unique, and increasing message timestamp. As
+// with Slack, the seconds are the current time, so that timestamps can be
+// compared with times like --since; the sequence number in the fractional part
+// keeps them unique. s.mu must be held.
+func (s *Server) nextTimestamp() string {
+	s.seq++
+	return fmt.Sprintf("%d.%06d", time.Now().Unix(), s.seq)
+}
+
+// recordPost records a message posted by a client, and returns it with its
+// newly assigned timestamp.
+func (s *Server) recordPost(msg Message) Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg.UserID = BotUserID
+	msg.Timestamp = s.nextTimestamp()
+	s.history[msg.ChannelID] = append(s.history[msg.ChannelID], msg)
+	s.posted = append(s.posted, msg)
+	s.cond.Broadcast()
+
+	return msg
+}
+
+func messageEvent(msg Message) map[string]interface{} {
+	event := map[string]interface{}{
+		"type":    "message",
+		"channel": msg.ChannelID,
+		"user":    msg.UserID,
+		"text":    msg.Text,
+		"ts":      msg.Timestamp,
+	}
+	if msg.ThreadTimestamp != "" {
+		event["thread_ts"] = msg.ThreadTimestamp
+	}
+	if msg.Broadcast {
+		event["subtype"] = "thread_broadcast"
+	}
+	if msg.UserID == BotUserID {
+		event["bot_id"] = BotID
+	}
+	if msg.Username != "" {
+		event["username"] = msg.Username
+	}
+	return event
+}
+
+func postEvent(url, secret string, callback interface{}) {
+	body, err := json.Marshal(callback)
+	if err != nil {
+		return
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+
+	token := r.FormValue("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		writeJSON(w, map[string]interface{}{"ok": false, "error": "not_authed"})
+		return
+	}
+
+	s.mu.Lock()
+	revoked := s.revoked[token]
+	s.mu.Unlock()
+	if revoked {
+		writeJSON(w, map[string]interface{}{"ok": false, "error": "token_revoked"})
+		return
+	}
+
+	switch method {
+	case "auth.test":
+		writeJSON(w, map[string]interface{}{
+			"ok":      true,
+			"url":     s.httpServer.URL + "/",
+			"team":    TeamName,
+			"user":    BotName,
+			"team_id": TeamID,
+			"user_id": BotUserID,
+			"bot_id":  BotID,
+		})
+
+	case "rtm.connect", "rtm.start":
+		writeJSON(w, map[string]interface{}{
+			"ok":   true,
+			"url":  s.wsURL("/ws/rtm"),
+			"self": map[string]interface{}{"id": BotUserID, "name": BotName},
+			"team": map[string]interface{}{"id": TeamID, "name": TeamName, "domain": TeamName},
+		})
+
+	case "apps.connections.open":
+		writeJSON(w, map[string]interface{}{"ok": true, "url": s.wsURL("/ws/socket")})
+
+	case "chat.postMessage":
+		s.mu.Lock()
+		_, ok := s.channels[r.FormValue("channel")]
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+			return
+		}
+
+		msg := s.recordPost(Message{
+			ChannelID:       r.FormValue("channel"),
+			Text:            r.FormValue("text"),
+			ThreadTimestamp: r.FormValue("thread_ts"),
+			Broadcast:       r.FormValue("reply_broadcast") == "true",
+			Username:        r.FormValue("username"),
+			IconEmoji:       r.FormValue("icon_emoji"),
+			IconURL:         r.FormValue("icon_url"),
+		})
+		writeJSON(w, map[string]interface{}{
+			"ok":      true,
+			"channel": msg.ChannelID,
+			"ts":      msg.Timestamp,
+			"message": messageEvent(msg),
+		})
+
+		// As with Slack, clients receive the messages that they post through
+		// the Web API.
+		s.deliver(msg)
+
+	case "conversations.list":
+		s.handleConversationsList(w, r, false)
+
+	case "users.conversations":
+		s.handleConversationsList(w, r, true)
+
+	case "conversations.info":
+		s.mu.Lock()
+		ch, ok := s.channels[r.FormValue("channel")]
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "channel": ch})
+
+	case "users.info":
+		s.mu.Lock()
+		user, ok := s.users[r.FormValue("user")]
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, map[string]interface{}{"ok": false, "error": "user_not_found"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ok": true, "user": user})
+
+	case "conversations.history":
+		s.handleConversationsHistory(w, r)
+
+	case "conversations.replies":
+		s.handleConversationsReplies(w, r)
+
+	default:
+		writeJSON(w, map[string]interface{}{"ok": false, "error": "unknown_method"})
+	}
+}
+
+func (s *Server) handleConversationsList(w http.ResponseWriter, r *http.Request, memberOnly bool) {
+	types := map[string]bool{}
+	for _, t := range strings.Split(r.FormValue("types"), ",") {
+		if t != "" {
+			types[t] = true
+		}
+	}
+
+	s.mu.Lock()
+	var channels []slack.Channel
+	for _, ch := range s.channels {
+		if memberOnly && !ch.IsMember && !ch.IsIM && !ch.IsMpIM {
+			continue
+		}
+		if len(types) == 0 || types[conversationType(ch)] {
+			channels = append(channels, ch)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].ID < channels[j].ID })
+	writeJSON(w, map[string]interface{}{
+		"ok":                true,
+		"channels":          channels,
+		"response_metadata": map[string]string{"next_cursor": ""},
+	})
+}
+
+// conversationType returns the conversations.list type name for ch.
+func conversationType(ch slack.Channel) string {
+	switch {
+	case ch.IsIM:
+		return "im"
+	case ch.IsMpIM:
+		return "mpim"
+	case ch.IsPrivate:
+		return "private_channel"
+	default:
+		return "public_channel"
+	}
+}
+
+func (s *Server) handleConversationsHistory(w http.ResponseWriter, r *http.Request) {
+	oldest, latest := r.FormValue("oldest"), r.FormValue("latest")
+	inclusive := r.FormValue("inclusive") == "true" || r.FormValue("inclusive") == "1"
+
+	s.mu.Lock()
+	replyCounts := s.replyCounts(r.FormValue("channel"))
+	var matches []Message
+	for _, msg := range s.history[r.FormValue("channel")] {
+		// Thread replies only appear in history if they were broadcast.
+		if msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp && !msg.Broadcast {
+			continue
+		}
+		if oldest != "" && !timestampAfter(msg.Timestamp, oldest, inclusive) {
+			continue
+		}
+		if latest != "" && !timestampAfter(latest, msg.Timestamp, inclusive) {
+			continue
+		}
+		matches = append(matches, msg)
+	}
+	s.mu.Unlock()
+
+	// Slack returns history from newest to oldest.
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+
+	page, nextCursor := paginate(len(matches), r.FormValue("cursor"), r.FormValue("limit"))
+	messages := make([]map[string]interface{}, 0, len(page))
+	for _, i := range page {
+		messages = append(messages, threadMessageEvent(matches[i], replyCounts))
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"ok":                true,
+		"messages":          messages,
+		"has_more":          nextCursor != "",
+		"response_metadata": map[string]string{"next_cursor": nextCursor},
+	})
+}
+
+func (s *Server) handleConversationsReplies(w http.ResponseWriter, r *http.Request) {
+	ts := r.FormValue("ts")
+
+	s.mu.Lock()
+	replyCounts := s.replyCounts(r.FormValue("channel"))
+	var matches []Message
+	for _, msg := range s.history[r.FormValue("channel")] {
+		if msg.Timestamp == ts || msg.ThreadTimestamp == ts {
+			matches = append(matches, msg)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(matches) == 0 {
+		writeJSON(w, map[string]interface{}{"ok": false, "error": "thread_not_found"})
+		return
+	}
+
+	page, nextCursor := paginate(len(matches), r.FormValue("cursor"), r.FormValue("limit"))
+	messages := make([]map[string]interface{}, 0, len(page))
+	for _, i := range page {
+		messages = append(messages, threadMessageEvent(matches[i], replyCounts))
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"ok":                true,
+		"messages":          messages,
+		"has_more":          nextCursor != "",
+		"response_metadata": map[string]string{"next_cursor": nextCursor},
+	})
+}
+
+// replyCounts returns the number of thread replies to each message in the given
+// channel, keyed by the timestamp of the parent message. s.mu must be held.
+func (s *Server) replyCounts(channelID string) map[string]int {
+	counts := make(map[string]int)
+	for _, msg := range s.history[channelID] {
+		if msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp {
+			counts[msg.ThreadTimestamp]++
+		}
+	}
+	return counts
+}
+
+// threadMessageEvent is like messageEvent, but marks the parent messages of
+// threads as Slack does in the history APIs.
+func threadMessageEvent(msg Message, replyCounts map[string]int) map[string]interface{} {
+	event := messageEvent(msg)
+	if n := replyCounts[msg.Timestamp]; n > 0 {
+		event["thread_ts"] = msg.Timestamp
+		event["reply_count"] = n
+	}
+	return event
+}
+
+// paginate returns the indices of the page of n results selected by the given
+// cursor and limit parameters, along with the cursor for the next page.
+func paginate(n int, cursor, limit string) (page []int, nextCursor string) {
+	start, _ := strconv.Atoi(cursor)
+	size, err := strconv.Atoi(limit)
+	if err != nil || size <= 0 {
+		size = 100
+	}
+
+	end := start + size
+	if end >= n {
+		end = n
+	} else {
+		nextCursor = strconv.Itoa(end)
+	}
+
+	for i := start; i < end; i++ {
+		page = append(page, i)
+	}
+	return
+}
+
+// timestampAfter reports whether Slack timestamp a is after b (or equal to b
+// if inclusive is set).
+func timestampAfter(a, b string, inclusive bool) bool {
+	return (inclusive && a == b) || slackio.TimestampAfter(a, b)
+}
+
+func (s *Server) wsURL(path string) string {
+	return "ws" + strings.TrimPrefix(s.httpServer.URL, "http") + path
+}
+
+func (s *Server) handleRTM(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn := &wsConn{Conn: ws}
+
+	s.mu.Lock()
+	s.rtmConns[conn] = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.rtmConns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	conn.writeJSON(map[string]string{"type": "hello"})
+
+	for {
+		var req struct {
+			ID      int    `json:"id"`
+			Type    string `json:"type"`
+			Channel string `json:"channel"`
+			Text    string `json:"text"`
+		}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+
+		switch req.Type {
+		case "ping":
+			conn.writeJSON(map[string]interface{}{
+				"type":     "pong",
+				"reply_to": req.ID,
+				"time":     time.Now().Unix(),
+			})
+
+		case "message":
+			msg := s.recordPost(Message{ChannelID: req.Channel, Text: req.Text})
+			conn.writeJSON(map[string]interface{}{
+				"ok":       true,
+				"reply_to": req.ID,
+				"ts":       msg.Timestamp,
+				"text":     msg.Text,
+			})
+		}
+	}
+}
+
+func (s *Server) handleSocketMode(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn := &wsConn{Conn: ws}
+
+	s.mu.Lock()
+	s.socketConn[conn] = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.socketConn, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	conn.writeJSON(map[string]string{"type": "hello"})
+
+	// Acknowledgements are not tracked; we only need to keep reading to detect
+	// when the client disconnects.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
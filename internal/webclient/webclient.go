@@ -0,0 +1,120 @@
+/*
+
+Package webclient implements a slackio.WriteClient that sends messages through
+Slack's Web API (specifically chat.postMessage) rather than the real-time
+messaging API.
+
+Messages sent over the real-time API always appear as plain text from the user
+associated with the API token. The Web API supports a number of additional
+features, including a custom username and icon for each message, which allow
+multiple bridges to post under distinct identities using a single token.
+
+*/
+package webclient // import "go.alexhamlin.co/slackbridge/internal/webclient"
+
+import (
+	"github.com/nlopes/slack"
+
+	"go.alexhamlin.co/slackbridge/internal/slackio"
+)
+
+// Options customizes the presentation of messages sent by a Client.
+type Options struct {
+	// Username, if non-blank, overrides the name displayed with each message.
+	Username string
+
+	// IconEmoji, if non-blank, overrides the icon displayed with each message
+	// using an emoji code (e.g. ":robot_face:").
+	IconEmoji string
+
+	// IconURL, if non-blank, overrides the icon displayed with each message
+	// using an image URL. IconEmoji takes precedence if both are set.
+	IconURL string
+
+	// NoUnfurl disables the unfurling of links and media in each message.
+	NoUnfurl bool
+}
+
+// Client sends slackio Messages using Slack's Web API. It implements the
+// slackio.WriteClient interface, and may be used in place of a slackio.Client
+// when constructing a slackio.Writer.
+type Client struct {
+	api  *slack.Client
+	opts Options
+
+	// ErrorHandler is called with any error encountered while sending a message
+	// through SendMessage. If nil, errors are silently discarded.
+	ErrorHandler func(error)
+
+	// PostHandler, if non-nil, is called with each message successfully sent
+	// through SendMessage, with its Timestamp set to the value assigned by Slack.
+	PostHandler func(slackio.Message)
+}
+
+// New returns a new Client that sends messages using the given API token.
+func New(apiToken string, opts Options) *Client {
+	return &Client{
+		api:  slack.New(apiToken),
+		opts: opts,
+	}
+}
+
+// SendMessage sends the given Message to its associated Slack channel. Since
+// the slackio.WriteClient interface does not allow for errors to be returned,
+// any errors are reported to the Client's ErrorHandler.
+func (c *Client) SendMessage(m slackio.Message) {
+	ts, err := c.PostMessage(m)
+	if err != nil {
+		if c.ErrorHandler != nil {
+			c.ErrorHandler(err)
+		}
+		return
+	}
+
+	if c.PostHandler != nil {
+		m.Timestamp = ts
+		c.PostHandler(m)
+	}
+}
+
+// PostMessage sends the given Message to its associated Slack channel (as a
+// thread reply, if its ThreadTimestamp is set), and returns the timestamp that
+// Slack assigned to the new message.
+func (c *Client) PostMessage(m slackio.Message) (timestamp string, err error) {
+	_, timestamp, err = c.api.PostMessage(m.ChannelID, c.msgOptions(m)...)
+	return
+}
+
+func (c *Client) msgOptions(m slackio.Message) []slack.MsgOption {
+	// Text written to a Writer is expected to already be formatted per Slack's
+	// conventions, just as with the real-time API, so we do not escape it.
+	opts := []slack.MsgOption{slack.MsgOptionText(m.Text, false)}
+
+	if m.ThreadTimestamp != "" {
+		opts = append(opts, slack.MsgOptionTS(m.ThreadTimestamp))
+		if m.Broadcast {
+			opts = append(opts, slack.MsgOptionBroadcast())
+		}
+	}
+
+	if c.opts.Username != "" {
+		opts = append(opts, slack.MsgOptionUsername(c.opts.Username))
+	}
+
+	if c.opts.IconEmoji != "" || c.opts.IconURL != "" {
+		opts = append(opts, slack.MsgOptionPostMessageParameters(slack.PostMessageParameters{
+			IconEmoji:   c.opts.IconEmoji,
+			IconURL:     c.opts.IconURL,
+			UnfurlMedia: slack.DEFAULT_MESSAGE_UNFURL_MEDIA,
+			Markdown:    slack.DEFAULT_MESSAGE_MARKDOWN,
+		}))
+	}
+
+	if c.opts.NoUnfurl {
+		opts = append(opts,
+			slack.MsgOptionDisableLinkUnfurl(),
+			slack.MsgOptionDisableMediaUnfurl())
+	}
+
+	return opts
+}
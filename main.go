@@ -1,9 +1,9 @@
 /*
 
 Command slackbridge connects Slack channels to system I/O streams using Slack's
-real-time messaging API.
+real-time messaging API, Socket Mode, or Events API.
 
-Three modes of execution are supported:
+Four modes of execution are supported:
 
 The first runs a child process and connects its standard streams to a Slack
 channel. Within the child process, the text of individual messages in the
@@ -16,6 +16,24 @@ process for each Slack channel from which a message is received.
 The third connects to Slack and streams message text to stdout. Input is
 ignored.
 
+The fourth forwards text from one channel to another (or in both
+directions), optionally between two workspaces, without a child process.
+
+The serve command runs any number of the above as "bridges" declared in a
+configuration file, sharing a single connection to Slack, and reloads the file
+on SIGHUP without disturbing unchanged bridges. When mux or serve is started
+with --admin, the ctl command lists its bridges and child processes, and can
+signal, restart, or pause the child of a channel.
+
+Other commands work with Slack without a persistent connection. The history
+command writes the past messages of a channel to stdout as text or JSON Lines,
+and can incrementally export them to a directory. The send command posts a
+single message, from its arguments or stdin, and exits once Slack has accepted
+it. The tee command copies stdin to stdout while also sending it, or only the
+lines matching a pattern, to a channel. The channels and whoami commands list
+the conversations visible to the API token and show its identity, which helps
+to find the channel IDs that the other commands require.
+
 Communication Model
 
 During its operation, slackbridge needs to convert Slack messages to and from
@@ -23,26 +41,63 @@ plain text.
 
 When reading, individual messages are delimited by newlines. Multi-line
 messages are equivalent to multiple single-line messages in succession. This
-is not configurable.
+is not configurable. However, the "--template" flag can render each line using
+Go's text/template syntax, with access to the names of the originating channel
+and user (resolved through Slack's Web API and cached), the message timestamp,
+and thread information. For example, "{{.Channel}} {{.User}}: {{.Text}}"
+identifies the source of each line in a combined stream.
 
 When writing, lines of output written within a 0.1 second interval are batched
 into a single Slack message. This is not configurable through the slackbridge
 CLI (though the underlying slackio package allows customization of this
 "batching" scheme).
 
-Users, reactions, threads, and other Slack features are not represented in any
-way. Only the text in the main body of the channel is available. Received
-messages are formatted per Slack's "Basic message formatting" as described at
+Users, reactions, and other Slack features are not represented in any way. By
+default, only the text in the main body of the channel is available. With the
+"--include-threads" flag, thread replies are received as well, with each line
+prefixed by a reference to the parent message (customizable through the
+"--thread-prefix" and "--broadcast-prefix" templates, the latter applying to
+replies that were also sent to the channel). Received messages are formatted
+per Slack's "Basic message formatting" as described at
 https://api.slack.com/docs/message-formatting. Sent messages should be
 formatted in this manner as well. slackbridge does not handle this
 automatically.
 
+Checkpoints
+
+By default, slackbridge only sees messages sent while it is running. With the
+"--state-dir" flag, exec, mux, and stream save the timestamp of the last message
+delivered from each channel in the given directory. When restarted with the
+same directory, they first replay any messages sent since those positions using
+Slack's conversations history API. The "--since" flag replays messages from an
+arbitrary point in time instead, and "--from-checkpoint=false" disables
+replaying from saved positions.
+
 Usage
 
 Run "slackbridge help" to view full usage information. Before using
 slackbridge, the SLACK_TOKEN environment variable must be set to a valid Slack
 API token.
 
+By default, slackbridge receives messages through Slack's legacy real-time
+messaging (RTM) API, which is not available to newer Slack apps. Such apps can
+instead use Socket Mode by passing "--receive-via=socket" and setting the
+SLACK_APP_TOKEN environment variable to an app-level token with the
+connections:write scope. In this case, SLACK_TOKEN must be a bot token, and
+messages are sent using the chat.postMessage Web API method.
+
+Where outbound WebSocket connections are unavailable, slackbridge can instead
+receive Events API callbacks over HTTP by passing "--receive-via=events". The
+SLACK_SIGNING_SECRET environment variable must be set to the app's signing
+secret, which is used to verify that each request came from Slack. The
+"--listen" flag sets the address of the HTTP server, which must be reachable
+through the app's configured Request URL.
+
+slackbridge verifies its API credentials with Slack before it starts. If Slack
+rejects them, either at startup or later (e.g. because a token was revoked),
+slackbridge prints an explanation and exits with status 3. Other fatal errors
+result in status 1.
+
 Caveats
 
 slackbridge is designed for long-running programs. Extremely short programs